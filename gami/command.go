@@ -1,8 +1,12 @@
 package gami
 
 import (
+	"context"
+	"crypto/md5"
 	"encoding/base64"
 	"fmt"
+	"sort"
+	"strings"
 )
 
 type ConfigAction string
@@ -18,81 +22,266 @@ const (
 	ConfInsert    ConfigAction = "Insert"
 )
 
-// DefaultHandler, set default handler for all Asterisk messages
+// DefaultHandler, set default handler for all Asterisk messages (pointer wrapper of OnMessage)
 func (a *Asterisk) DefaultHandler(f *func(Message)) {
 
-	a.defaultHandler = f
+	a.OnMessage(deref(f))
 }
 
-// Login, logins to AMI and starts read dispatcher
-func (a *Asterisk) Login(login string, password string) error {
+// AuthType, AMI login authentication type
+type AuthType string
+
+const (
+	AuthPlain AuthType = ""    // Secret sent in cleartext
+	AuthMD5   AuthType = "MD5" // challenge-response, Secret never sent
+)
+
+// SetAuthType, set authentication type used by Login (and replayed on reconnect)
+func (a *Asterisk) SetAuthType(t AuthType) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.authType = t
+}
+
+// md5Key, Login Key for MD5 challenge
+func md5Key(challenge, secret string) string {
+
+	return fmt.Sprintf("%x", md5.Sum([]byte(challenge+secret)))
+}
+
+// exchange, sends action regardless of authorization and waits for response
+func (a *Asterisk) exchange(ctx context.Context, m Message) (Message, error) {
+
+	m["ActionID"] = a.aid.Generate()
+
+	return a.await(ctx, m["ActionID"], func() error {
+		return a.send(ctx, m)
+	})
+}
 
-	go a.readDispatcher()
+// await, registers callback for action id, sends packet and waits for response
+func (a *Asterisk) await(ctx context.Context, aid string, send func() error) (Message, error) {
 
-	lhc := make(chan error)
-	lhf := func(m Message) {
-		if m["Response"] == "Success" {
-			lhc <- nil
-		} else {
-			lhc <- fmt.Errorf("%s", m["Message"])
+	rc := make(chan Message, 1)
+	f := func(m Message) {
+		rc <- m
+	}
+
+	a.actionHandlers.set(aid, &f, false)
+
+	if err := send(); err != nil {
+		a.actionHandlers.del(aid)
+		return nil, err
+	}
+
+	select {
+	case r := <-rc:
+		err := ResponseError(r)
+		if err == ErrConnectionClosed {
+			return nil, err
 		}
+		return r, err
+	case <-ctx.Done():
+		a.actionHandlers.del(aid)
+		return nil, contextError(ctx)
 	}
+}
+
+// Login, logins to AMI and starts read dispatcher
+func (a *Asterisk) Login(login string, password string) error {
 
-	aid := a.aid.Generate()
-	a.actionHandlers.set(aid, &lhf, false)
+	return a.login(context.Background(), login, password)
+}
+
+// login, Login limited by ctx
+func (a *Asterisk) login(ctx context.Context, login string, password string) error {
+
+	// reader is bound to connection of this Login, not to one current when it starts
+	conn := a.connection()
+	ready, done := make(chan struct{}), make(chan struct{})
+	a.mu.Lock()
+	a.readerDone = done
+	a.mu.Unlock()
+
+	go a.readDispatcher(conn, ready, done)
+
+	// version is checked before credentials are sent
+	if err := a.waitVersion(ctx, ready); err != nil {
+		return err
+	}
 
 	m := Message{
 		"Action":   "Login",
 		"Username": login,
-		"Secret":   password,
-		"ActionID": aid,
 	}
-	a.send(m)
 
-	err := <-lhc
+	a.mu.RLock()
+	authType := a.authType
+	a.mu.RUnlock()
+
+	if authType == AuthMD5 {
+		r, err := a.exchange(ctx, Message{
+			"Action":   "Challenge",
+			"AuthType": string(AuthMD5),
+		})
+		if err != nil {
+			return err
+		}
+		if r["Response"] != "Success" {
+			return newAMIError(r)
+		}
+
+		m["AuthType"] = string(AuthMD5)
+		m["Key"] = md5Key(r["Challenge"], password)
+	} else {
+		m["Secret"] = password
+	}
+
+	mask, filters := a.sessionOptions()
+	if mask != "" {
+		m["Events"] = mask
+	}
 
+	r, err := a.exchange(ctx, m)
 	if err != nil {
 		return err
 	}
+	if r["Response"] != "Success" {
+		return newAMIError(r)
+	}
 
-	a.authorized = true
+	// filters are per session, apply again before session is usable
+	for _, expr := range filters {
+		if r, err = a.exchange(ctx, filterAction(expr)); err == nil {
+			err = ResponseError(r)
+		}
+		if err != nil {
+			a.exchange(ctx, Message{"Action": "Logoff"}) // do not leave half configured session
+			return err
+		}
+	}
+
+	a.setAuthorized(true)
+
+	if a.rc != nil {
+		a.rc.setCredentials(login, password)
+	}
+
+	a.startKeepalive()
 
 	return nil
 }
 
-// SendAction, universal action send
-func (a *Asterisk) SendAction(m Message, f *func(m Message)) error {
+// sessionOptions, returns event mask and filters for new session
+func (a *Asterisk) sessionOptions() (string, []string) {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.eventMask, append([]string(nil), a.filters...)
+}
+
+// SetEventMask, set event mask sent on Login ("on", "off" or classes list "system,call,agent")
+func (a *Asterisk) SetEventMask(mask string) {
 
-	if !a.authorized {
-		return fmt.Errorf("Not authorized")
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.eventMask = mask
+}
+
+// EventMask, change event mask of current session (Events action), mask is kept for next logins
+func (a *Asterisk) EventMask(ctx context.Context, mask string) error {
+
+	m := Message{
+		"Action":    "Events",
+		"EventMask": mask,
 	}
 
-	m["ActionID"] = a.aid.Generate()
+	if _, err := a.SendActionContext(ctx, m); err != nil {
+		return err
+	}
+
+	a.SetEventMask(mask)
+
+	return nil
+}
+
+// filterAction, Filter action message
+func filterAction(expr string) Message {
+	return Message{
+		"Action":    "Filter",
+		"Operation": "Add",
+		"Filter":    expr,
+	}
+}
+
+// AddFilter, install server side event filter (regex, "!" prefix excludes),
+// filter is installed again on each Login
+func (a *Asterisk) AddFilter(ctx context.Context, expr string) error {
 
-	if f != nil {
-		a.actionHandlers.set(m["ActionID"], f, false)
+	if _, err := a.SendActionContext(ctx, filterAction(expr)); err != nil {
+		return err
 	}
 
-	return a.send(m)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.filters = append(a.filters, expr)
+
+	return nil
 }
 
-// HoldCallbackAction, send action with callback which deletes itself (used for multi-line responses)
-// IMPORTANT: callback function must delete itself by own
-func (a *Asterisk) HoldCallbackAction(m Message, f *func(m Message)) error {
+// Filters, returns installed server side filters
+func (a *Asterisk) Filters() []string {
+
+	_, filters := a.sessionOptions()
+	return filters
+}
+
+// SendAction, universal action send (pointer wrapper of Send)
+func (a *Asterisk) SendAction(m Message, f *func(m Message)) error {
+
+	_, err := a.Send(m, deref(f))
+	return err
+}
 
-	if !a.authorized {
-		return fmt.Errorf("Not authorized")
+// SendActionContext, send action and wait for response (blocks until response or ctx done)
+// if ctx is done before response, callback is removed and ctx error returned
+// Response: Error is returned as *AMIError together with response message
+func (a *Asterisk) SendActionContext(ctx context.Context, m Message) (Message, error) {
+
+	if !a.isAuthorized() {
+		return nil, ErrNotAuthorized
 	}
 
 	m["ActionID"] = a.aid.Generate()
 
-	if f == nil {
-		return fmt.Errorf("Use SendAction with nil callback!")
+	return a.await(ctx, m["ActionID"], func() error {
+		return a.send(ctx, m)
+	})
+}
+
+// SendHeadersContext, like SendActionContext but headers are sent in given order
+// with repeated keys kept (Action should be first), ActionID is replaced
+func (a *Asterisk) SendHeadersContext(ctx context.Context, h Headers) (Message, error) {
+
+	if !a.isAuthorized() {
+		return nil, ErrNotAuthorized
 	}
 
-	a.actionHandlers.set(m["ActionID"], f, true)
+	h = append(Headers(nil), h...)
+	h.Set("ActionID", a.aid.Generate())
 
-	return a.send(m)
+	return a.await(ctx, h.Get("ActionID"), func() error {
+		return a.write(ctx, h.marshal())
+	})
+}
+
+// HoldCallbackAction, send action with callback which deletes itself (used for multi-line responses)
+// IMPORTANT: callback function must delete itself by own (pointer wrapper of SendHold)
+func (a *Asterisk) HoldCallbackAction(m Message, f *func(m Message)) error {
+
+	_, err := a.SendHold(m, deref(f))
+	return err
 }
 
 // DelCallback, delete action callback (used by self-delete callbacks)
@@ -101,33 +290,116 @@ func (a *Asterisk) DelCallback(m Message) {
 	a.actionHandlers.del(m["ActionID"])
 }
 
-// Hangup, hangup Asterisk channel
-func (a Asterisk) Hangup(channel string, f *func(Message)) error {
+// listEnd, reports if message completes EventList (or legacy *Complete event without EventList)
+func listEnd(m Message) bool {
 
-	m := Message{
+	if v, ok := m["EventList"]; ok {
+		return strings.EqualFold(v, "Complete")
+	}
+
+	return strings.HasSuffix(m["Event"], "Complete")
+}
+
+// CollectList, send list action (CoreShowChannels, Status, QueueStatus, SIPpeers...)
+// and collect events until EventList completes, result has no start response and complete event
+// blocks until end, Error response or ctx done
+func (a *Asterisk) CollectList(ctx context.Context, m Message) ([]Message, error) {
+
+	ml := []Message{}
+	done := make(chan error, 1)
+
+	// callbacks for one ActionID are serialized, ml is read only after done
+	// messages dispatched before callback is removed are ignored after finish
+	finished := false
+	finish := func(r Message, err error) {
+		a.DelCallback(r)
+		finished = true
+		select {
+		case done <- err:
+		default:
+		}
+	}
+
+	f := func(r Message) {
+		if finished {
+			return
+		}
+		switch {
+		case r["Response"] == "Error": // ErrConnectionClosed too
+			finish(r, ResponseError(r))
+		case r["Response"] != "": // list start
+		case listEnd(r):
+			finish(r, nil)
+		default:
+			ml = append(ml, r)
+		}
+	}
+
+	if _, err := a.SendHold(m, f); err != nil {
+		return nil, err
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+		return ml, nil
+	case <-ctx.Done():
+		a.DelCallback(m)
+		return nil, contextError(ctx)
+	}
+}
+
+// hangupAction, Hangup action message
+func hangupAction(channel string) Message {
+	return Message{
 		"Action":  "Hangup",
 		"Channel": channel,
 	}
+}
 
-	return a.SendAction(m, f)
+// Hangup, hangup Asterisk channel
+func (a *Asterisk) Hangup(channel string, f *func(Message)) error {
+
+	return a.SendAction(hangupAction(channel), f)
 }
 
-// Redirect, redirect Asterisk channel
-func (a Asterisk) Redirect(channel string, context string, exten string, priority string, f *func(Message)) error {
+// HangupContext, hangup Asterisk channel and wait for response
+func (a *Asterisk) HangupContext(ctx context.Context, channel string) (Message, error) {
 
-	m := Message{
+	return a.SendActionContext(ctx, hangupAction(channel))
+}
+
+// redirectAction, Redirect action message
+func redirectAction(channel string, context string, exten string, priority string) Message {
+	return Message{
 		"Action":   "Redirect",
 		"Channel":  channel,
 		"Context":  context,
 		"Exten":    exten,
 		"Priority": priority,
 	}
+}
 
-	return a.SendAction(m, f)
+// Redirect, redirect Asterisk channel
+func (a *Asterisk) Redirect(channel string, context string, exten string, priority string, f *func(Message)) error {
+
+	return a.SendAction(redirectAction(channel, context, exten, priority), f)
 }
 
-// Logoff, logoff from AMI
-func (a Asterisk) Logoff() error {
+// RedirectContext, redirect Asterisk channel and wait for response
+func (a *Asterisk) RedirectContext(ctx context.Context, channel string, context string, exten string, priority string) (Message, error) {
+
+	return a.SendActionContext(ctx, redirectAction(channel, context, exten, priority))
+}
+
+// Logoff, logoff from AMI (disables reconnect for client which owns a dialer)
+func (a *Asterisk) Logoff() error {
+
+	if a.rc != nil {
+		a.rc.close()
+	}
 
 	m := Message{
 		"Action": "Logoff",
@@ -136,8 +408,50 @@ func (a Asterisk) Logoff() error {
 	return a.SendAction(m, nil)
 }
 
-// Originate, make a call
-func (a *Asterisk) Originate(o *Originate, vars map[string]string, f *func(Message)) error {
+// variableEscaper, escapes characters special for Asterisk arguments parser
+var variableEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	",", "\\,",
+	"\"", "\\\"",
+	"\r", "",
+	"\n", "",
+)
+
+// escapeVariable, escapes variable name or value for Variable header
+func escapeVariable(s string) string {
+
+	return variableEscaper.Replace(s)
+}
+
+// headerValue, replaces line breaks (value would be sent as several headers)
+func headerValue(s string) string {
+
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// setVariables, adds channel variables to action, each one as own Variable header
+func setVariables(m Message, vars map[string]string) {
+
+	if len(vars) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	vl := make([]string, 0, len(keys))
+	for _, k := range keys {
+		vl = append(vl, escapeVariable(k)+"="+escapeVariable(vars[k]))
+	}
+
+	m["Variable"] = strings.Join(vl, _MULTI_SEP)
+}
+
+// originateAction, Originate action message
+func originateAction(o *Originate, vars map[string]string) Message {
 
 	m := Message{
 		"Action":   "Originate",
@@ -160,43 +474,45 @@ func (a *Asterisk) Originate(o *Originate, vars map[string]string, f *func(Messa
 		m["Priority"] = o.Priority
 	}
 
-	if vars != nil {
-		var vl string
+	setVariables(m, vars)
 
-		for k, v := range vars {
-			vl += k + "=" + v + ","
-		}
+	return m
+}
 
-		m["Variable"] = vl[:len(vl)-1]
-	}
+// Originate, make a call
+func (a *Asterisk) Originate(o *Originate, vars map[string]string, f *func(Message)) error {
 
-	return a.SendAction(m, f)
+	return a.SendAction(originateAction(o, vars), f)
 }
 
-// RegisterHandler, register callback for Asterisk event (one handler per event)
-// return err if handler already exists
-func (a *Asterisk) RegisterHandler(event string, f *func(m Message)) error {
+// OriginateContext, make a call and wait for response
+func (a *Asterisk) OriginateContext(ctx context.Context, o *Originate, vars map[string]string) (Message, error) {
 
-	if f, _ := a.eventHandlers.get(event); f != nil {
-		return fmt.Errorf("Handler already exist for event %s", event)
-	}
+	return a.SendActionContext(ctx, originateAction(o, vars))
+}
 
-	a.eventHandlers.set(event, f, false)
+// RegisterHandler, register callback for Asterisk event (one handler per event)
+// return err if handler already exists (pointer wrapper of On)
+func (a *Asterisk) RegisterHandler(event string, f *func(m Message)) error {
 
-	return nil
+	return a.register(event, deref(f))
 }
 
 // UnregisterHandler, deregister callback for event
 func (a *Asterisk) UnregisterHandler(event string) {
-	a.eventHandlers.del(event)
+	a.unregister(event)
 }
 
-// Bridge, bridge two channels already in the PBX
-func (a *Asterisk) Bridge(chan1, chan2 string, tone bool, f *func(Message)) error {
+// bridgeAction, Bridge action message
+// since AMI 2.0 Tone is no|Channel1|Channel2|Both, old "yes" meant Channel2
+func bridgeAction(v Version, chan1, chan2 string, tone bool) Message {
 
 	t := "no"
 	if tone {
 		t = "yes"
+		if !v.Less(AMI12) {
+			t = "Channel2"
+		}
 	}
 
 	m := Message{
@@ -206,17 +522,68 @@ func (a *Asterisk) Bridge(chan1, chan2 string, tone bool, f *func(Message)) erro
 		"Tone":     t,
 	}
 
-	return a.SendAction(m, f)
+	return m
 }
 
-// Command, execute Asterisk CLI Command
-func (a *Asterisk) Command(cmd string, f *func(Message)) error {
+// Bridge, bridge two channels already in the PBX
+func (a *Asterisk) Bridge(chan1, chan2 string, tone bool, f *func(Message)) error {
+
+	return a.SendAction(bridgeAction(a.Version(), chan1, chan2, tone), f)
+}
+
+// BridgeContext, bridge two channels and wait for response
+func (a *Asterisk) BridgeContext(ctx context.Context, chan1, chan2 string, tone bool) (Message, error) {
+
+	return a.SendActionContext(ctx, bridgeAction(a.Version(), chan1, chan2, tone))
+}
+
+// commandAction, Command action message
+func commandAction(cmd string) Message {
 	m := Message{
 		"Action":  "Command",
 		"Command": cmd,
 	}
 
-	return a.SendAction(m, f)
+	return m
+}
+
+// Command, execute Asterisk CLI Command
+func (a *Asterisk) Command(cmd string, f *func(Message)) error {
+
+	return a.SendAction(commandAction(cmd), f)
+}
+
+// CommandContext, execute Asterisk CLI Command and wait for response
+func (a *Asterisk) CommandContext(ctx context.Context, cmd string) (Message, error) {
+
+	return a.SendActionContext(ctx, commandAction(cmd))
+}
+
+// commandOutput, CLI output lines of Command response
+// AMI 3.0+ (Asterisk 14) sends Output headers, older versions Response: Follows with raw text
+func commandOutput(r Message) []string {
+
+	if _, ok := r["Output"]; ok {
+		return r.Values("Output")
+	}
+
+	if _, ok := r["CmdData"]; ok {
+		return r.Values("CmdData")
+	}
+
+	return []string{}
+}
+
+// CommandOutput, execute Asterisk CLI Command and return output line by line
+// (output is returned together with error if Asterisk reports command failure)
+func (a *Asterisk) CommandOutput(ctx context.Context, cmd string) ([]string, error) {
+
+	r, err := a.CommandContext(ctx, cmd)
+	if r == nil {
+		return nil, err
+	}
+
+	return commandOutput(r), err
 }
 
 // ConfbridgeList, list participants in a conference (generates multimessage response)
@@ -236,43 +603,34 @@ func (a *Asterisk) GetConfbridgeList(conference string) ([]Message, error) {
 		"Conference": conference,
 	}
 
-	ml := []Message{}
-	mc := make(chan []Message)
-
-	clj := func(m Message) {
-		if m["Event"] == "ConfbridgeListComplete" && m["EventList"] == "Complete" ||
-			m["Response"] == "Error" {
-			a.DelCallback(m)
-			mc <- ml
-		}
-		if m["EventList"] == "start" {
-			return
-		}
-		ml = append(ml, m)
-	}
-
-	err := a.HoldCallbackAction(m, &clj)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return <-mc, nil
+	return a.CollectList(context.Background(), m)
 }
 
-// ConfbridgeKick, kick a Confbridge user
-func (a *Asterisk) ConfbridgeKick(conf, chann string, f *func(Message)) error {
+// confbridgeKickAction, ConfbridgeKick action message
+func confbridgeKickAction(conf, chann string) Message {
 	m := Message{
 		"Action":     "ConfbridgeKick",
 		"Conference": conf,
 		"Channel":    chann,
 	}
 
-	return a.SendAction(m, f)
+	return m
 }
 
-// ConfbridgeToggleMute, mute/unmute a Confbridge user
-func (a *Asterisk) ConfbridgeToggleMute(conf, chann string, mute bool, f *func(Message)) error {
+// ConfbridgeKick, kick a Confbridge user
+func (a *Asterisk) ConfbridgeKick(conf, chann string, f *func(Message)) error {
+
+	return a.SendAction(confbridgeKickAction(conf, chann), f)
+}
+
+// ConfbridgeKickContext, kick a Confbridge user and wait for response
+func (a *Asterisk) ConfbridgeKickContext(ctx context.Context, conf, chann string) (Message, error) {
+
+	return a.SendActionContext(ctx, confbridgeKickAction(conf, chann))
+}
+
+// confbridgeMuteAction, ConfbridgeMute/ConfbridgeUnmute action message
+func confbridgeMuteAction(conf, chann string, mute bool) Message {
 	m := Message{
 		"Conference": conf,
 		"Channel":    chann,
@@ -284,11 +642,23 @@ func (a *Asterisk) ConfbridgeToggleMute(conf, chann string, mute bool, f *func(M
 		m["Action"] = "ConfbridgeUnmute"
 	}
 
-	return a.SendAction(m, f)
+	return m
 }
 
-// ConfbridgeStartRecord, start conference record
-func (a *Asterisk) ConfbridgeStartRecord(conf, file string, f *func(Message)) error {
+// ConfbridgeToggleMute, mute/unmute a Confbridge user
+func (a *Asterisk) ConfbridgeToggleMute(conf, chann string, mute bool, f *func(Message)) error {
+
+	return a.SendAction(confbridgeMuteAction(conf, chann, mute), f)
+}
+
+// ConfbridgeToggleMuteContext, mute/unmute a Confbridge user and wait for response
+func (a *Asterisk) ConfbridgeToggleMuteContext(ctx context.Context, conf, chann string, mute bool) (Message, error) {
+
+	return a.SendActionContext(ctx, confbridgeMuteAction(conf, chann, mute))
+}
+
+// confbridgeStartRecordAction, ConfbridgeStartRecord action message
+func confbridgeStartRecordAction(conf, file string) Message {
 
 	m := Message{
 		"Action":     "ConfbridgeStartRecord",
@@ -299,17 +669,41 @@ func (a *Asterisk) ConfbridgeStartRecord(conf, file string, f *func(Message)) er
 		m["RecordFile"] = file
 	}
 
-	return a.SendAction(m, f)
+	return m
 }
 
-// ConfbridgeStopRecord, stop conference record
-func (a *Asterisk) ConfbridgeStopRecord(conf string, f *func(Message)) error {
+// ConfbridgeStartRecord, start conference record
+func (a *Asterisk) ConfbridgeStartRecord(conf, file string, f *func(Message)) error {
+
+	return a.SendAction(confbridgeStartRecordAction(conf, file), f)
+}
+
+// ConfbridgeStartRecordContext, start conference record and wait for response
+func (a *Asterisk) ConfbridgeStartRecordContext(ctx context.Context, conf, file string) (Message, error) {
+
+	return a.SendActionContext(ctx, confbridgeStartRecordAction(conf, file))
+}
+
+// confbridgeStopRecordAction, ConfbridgeStopRecord action message
+func confbridgeStopRecordAction(conf string) Message {
 	m := Message{
 		"Action":     "ConfbridgeStopRecord",
 		"Conference": conf,
 	}
 
-	return a.SendAction(m, f)
+	return m
+}
+
+// ConfbridgeStopRecord, stop conference record
+func (a *Asterisk) ConfbridgeStopRecord(conf string, f *func(Message)) error {
+
+	return a.SendAction(confbridgeStopRecordAction(conf), f)
+}
+
+// ConfbridgeStopRecordContext, stop conference record and wait for response
+func (a *Asterisk) ConfbridgeStopRecordContext(ctx context.Context, conf string) (Message, error) {
+
+	return a.SendActionContext(ctx, confbridgeStopRecordAction(conf))
 }
 
 // MeetmeList, list participants in a MeetMe conference (generates multimessage response)
@@ -336,80 +730,133 @@ func (a *Asterisk) GetMeetmeList(conference string) ([]Message, error) {
 		m["Conference"] = conference
 	}
 
-	ml := []Message{}
-	mc := make(chan []Message)
-
-	clj := func(m Message) {
-		if m["Event"] == "MeetmeListComplete" && m["EventList"] == "Complete" ||
-			m["Response"] == "Error" {
-			a.DelCallback(m)
-			mc <- ml
-		}
-		if m["EventList"] == "start" {
-			return
-		}
-		ml = append(ml, m)
-	}
+	return a.CollectList(context.Background(), m)
+}
 
-	err := a.HoldCallbackAction(m, &clj)
+// moduleLoadAction, ModuleLoad action message
+func moduleLoadAction(module, tload string) Message {
 
-	if err != nil {
-		return nil, err
+	m := Message{
+		"Action":   "ModuleLoad",
+		"LoadType": tload,
+		"Module":   module,
 	}
 
-	return <-mc, nil
+	return m
 }
 
 // ModuleLoad, loads, unloads or reloads an Asterisk module in a running system
 func (a *Asterisk) ModuleLoad(module, tload string, f *func(Message)) error {
 
-	m := Message{
-		"Action":   "ModuleLoad",
-		"LoadType": tload,
-		"Module":   module,
-	}
+	return a.SendAction(moduleLoadAction(module, tload), f)
+}
 
-	return a.SendAction(m, f)
+// ModuleLoadContext, loads, unloads or reloads an Asterisk module and wait for response
+func (a *Asterisk) ModuleLoadContext(ctx context.Context, module, tload string) (Message, error) {
+
+	return a.SendActionContext(ctx, moduleLoadAction(module, tload))
 }
 
-// Reload, reload Asterisk module
-func (a *Asterisk) Reaload(module string, f *func(Message)) error {
+// reloadAction, Reload action message
+func reloadAction(module string) Message {
 
 	m := Message{
 		"Action": "Reload",
 		"Module": module,
 	}
 
-	return a.SendAction(m, f)
+	return m
 }
 
-// UserEvent, send an arbitrary event
-func (a *Asterisk) UserEvent(name string, headers map[string]string, f *func(Message)) error {
+// Reload, reload Asterisk module
+func (a *Asterisk) Reaload(module string, f *func(Message)) error {
+
+	return a.SendAction(reloadAction(module), f)
+}
+
+// ReloadContext, reload Asterisk module and wait for response
+func (a *Asterisk) ReloadContext(ctx context.Context, module string) (Message, error) {
+
+	return a.SendActionContext(ctx, reloadAction(module))
+}
+
+// userEventAction, UserEvent action message
+func userEventAction(name string, headers map[string]string) Message {
 	m := Message{
 		"Action":    "UserEvent",
 		"UserEvent": name,
 	}
 
 	for k, v := range headers {
-		m[k] = v
+		m[k] = headerValue(v)
 	}
 
-	return a.SendAction(m, f)
+	return m
 }
 
-// DbGet, retrive data from Asterisk DB, response must be processed in f
-func (a *Asterisk) DbGet(family, key string, f *func(Message)) error {
+// UserEvent, send an arbitrary event
+func (a *Asterisk) UserEvent(name string, headers map[string]string, f *func(Message)) error {
+
+	return a.SendAction(userEventAction(name, headers), f)
+}
+
+// UserEventContext, send an arbitrary event and wait for response
+func (a *Asterisk) UserEventContext(ctx context.Context, name string, headers map[string]string) (Message, error) {
+
+	return a.SendActionContext(ctx, userEventAction(name, headers))
+}
+
+// dbGetAction, DBGet action message
+func dbGetAction(family, key string) Message {
 	m := Message{
 		"Action": "DBGet",
 		"Family": family,
 		"Key":    key,
 	}
 
-	return a.SendAction(m, f)
+	return m
 }
 
-// DbPut, put data to Asterisk DB
-func (a *Asterisk) DbPut(family, key, value string, f *func(Message)) error {
+// DbGet, retrive data from Asterisk DB, response must be processed in f
+func (a *Asterisk) DbGet(family, key string, f *func(Message)) error {
+
+	return a.SendAction(dbGetAction(family, key), f)
+}
+
+// DbGetContext, retrive data from Asterisk DB (waits for DBGetResponse event)
+func (a *Asterisk) DbGetContext(ctx context.Context, family, key string) (string, error) {
+
+	rc := make(chan Message, 1)
+	f := func(m Message) {
+		if m["Response"] == "Error" || strings.EqualFold(m["Event"], "DBGetResponse") {
+			a.DelCallback(m)
+			select {
+			case rc <- m:
+			default:
+			}
+		}
+	}
+
+	m := dbGetAction(family, key)
+	if _, err := a.SendHold(m, f); err != nil {
+		a.actionHandlers.del(m["ActionID"])
+		return "", err
+	}
+
+	select {
+	case r := <-rc:
+		if err := ResponseError(r); err != nil {
+			return "", err
+		}
+		return r["Val"], nil
+	case <-ctx.Done():
+		a.actionHandlers.del(m["ActionID"])
+		return "", contextError(ctx)
+	}
+}
+
+// dbPutAction, DBPut action message
+func dbPutAction(family, key, value string) Message {
 	m := Message{
 		"Action": "DBPut",
 		"Family": family,
@@ -417,22 +864,46 @@ func (a *Asterisk) DbPut(family, key, value string, f *func(Message)) error {
 		"Value":  value,
 	}
 
-	return a.SendAction(m, f)
+	return m
 }
 
-// DbDel, remove value from Asterisk DB
-func (a *Asterisk) DbDel(family, key string, f *func(Message)) error {
+// DbPut, put data to Asterisk DB
+func (a *Asterisk) DbPut(family, key, value string, f *func(Message)) error {
+
+	return a.SendAction(dbPutAction(family, key, value), f)
+}
+
+// DbPutContext, put data to Asterisk DB and wait for response
+func (a *Asterisk) DbPutContext(ctx context.Context, family, key, value string) (Message, error) {
+
+	return a.SendActionContext(ctx, dbPutAction(family, key, value))
+}
+
+// dbDelAction, DBDel action message
+func dbDelAction(family, key string) Message {
 	m := Message{
 		"Action": "DBDel",
 		"Family": family,
 		"Key":    key,
 	}
 
-	return a.SendAction(m, f)
+	return m
 }
 
-// DbDelTree, remove family tree from Asterisk DB
-func (a *Asterisk) DbDelTree(family, key string, f *func(Message)) error {
+// DbDel, remove value from Asterisk DB
+func (a *Asterisk) DbDel(family, key string, f *func(Message)) error {
+
+	return a.SendAction(dbDelAction(family, key), f)
+}
+
+// DbDelContext, remove value from Asterisk DB and wait for response
+func (a *Asterisk) DbDelContext(ctx context.Context, family, key string) (Message, error) {
+
+	return a.SendActionContext(ctx, dbDelAction(family, key))
+}
+
+// dbDelTreeAction, DBDelTree action message
+func dbDelTreeAction(family, key string) Message {
 	m := Message{
 		"Action": "DBDelTree",
 		"Family": family,
@@ -442,11 +913,24 @@ func (a *Asterisk) DbDelTree(family, key string, f *func(Message)) error {
 		m["Key"] = key
 	}
 
-	return a.SendAction(m, f)
+	return m
 }
 
-// MessageSend, send message (pjsip, sip, xmpp)
-func (a *Asterisk) MessageSend(to, from, body string, useBase64 bool, vars map[string]string, f *func(Message)) error {
+// DbDelTree, remove family tree from Asterisk DB
+func (a *Asterisk) DbDelTree(family, key string, f *func(Message)) error {
+
+	return a.SendAction(dbDelTreeAction(family, key), f)
+}
+
+// DbDelTreeContext, remove family tree from Asterisk DB and wait for response
+func (a *Asterisk) DbDelTreeContext(ctx context.Context, family, key string) (Message, error) {
+
+	return a.SendActionContext(ctx, dbDelTreeAction(family, key))
+}
+
+// messageSendAction, MessageSend action message, body with line breaks is sent as Base64Body
+// (Body header can not carry them)
+func messageSendAction(to, from, body string, useBase64 bool, vars map[string]string) Message {
 
 	m := Message{
 		"Action": "MessageSend",
@@ -454,27 +938,31 @@ func (a *Asterisk) MessageSend(to, from, body string, useBase64 bool, vars map[s
 		"From":   from,
 	}
 
-	if useBase64 {
+	if useBase64 || strings.ContainsAny(body, "\r\n") {
 		m["Base64Body"] = base64.StdEncoding.EncodeToString([]byte(body))
 	} else {
 		m["Body"] = body
 	}
 
-	if vars != nil {
-		var vl string
+	setVariables(m, vars)
 
-		for k, v := range vars {
-			vl += k + "=" + v + ","
-		}
+	return m
+}
 
-		m["Variable"] = vl[:len(vl)-1]
-	}
+// MessageSend, send message (pjsip, sip, xmpp), multi-line body is always base64 encoded
+func (a *Asterisk) MessageSend(to, from, body string, useBase64 bool, vars map[string]string, f *func(Message)) error {
 
-	return a.SendAction(m, f)
+	return a.SendAction(messageSendAction(to, from, body, useBase64, vars), f)
 }
 
-// GetVar, get variable (response must be handled with callback function)
-func (a *Asterisk) GetVar(name, channel string, f *func(Message)) error {
+// MessageSendContext, send message (pjsip, sip, xmpp) and wait for response
+func (a *Asterisk) MessageSendContext(ctx context.Context, to, from, body string, useBase64 bool, vars map[string]string) (Message, error) {
+
+	return a.SendActionContext(ctx, messageSendAction(to, from, body, useBase64, vars))
+}
+
+// getVarAction, GetVar action message
+func getVarAction(name, channel string) Message {
 	m := Message{
 		"Action":   "GetVar",
 		"Variable": name,
@@ -484,11 +972,28 @@ func (a *Asterisk) GetVar(name, channel string, f *func(Message)) error {
 		m["Channel"] = channel
 	}
 
-	return a.SendAction(m, f)
+	return m
 }
 
-// SetVar, set variable
-func (a *Asterisk) SetVar(name, value, channel string, f *func(Message)) error {
+// GetVar, get variable (response must be handled with callback function)
+func (a *Asterisk) GetVar(name, channel string, f *func(Message)) error {
+
+	return a.SendAction(getVarAction(name, channel), f)
+}
+
+// GetVarContext, get variable value (waits for response)
+func (a *Asterisk) GetVarContext(ctx context.Context, name, channel string) (string, error) {
+
+	r, err := a.SendActionContext(ctx, getVarAction(name, channel))
+	if err != nil {
+		return "", err
+	}
+
+	return r["Value"], nil
+}
+
+// setVarAction, SetVar action message
+func setVarAction(name, value, channel string) Message {
 	m := Message{
 		"Action":   "SetVar",
 		"Variable": name,
@@ -499,21 +1004,45 @@ func (a *Asterisk) SetVar(name, value, channel string, f *func(Message)) error {
 		m["Channel"] = channel
 	}
 
-	return a.SendAction(m, f)
+	return m
 }
 
-// CreateConfig, create empty Asterisk config
-func (a *Asterisk) CreateConfig(filename string, f *func(Message)) error {
+// SetVar, set variable
+func (a *Asterisk) SetVar(name, value, channel string, f *func(Message)) error {
+
+	return a.SendAction(setVarAction(name, value, channel), f)
+}
+
+// SetVarContext, set variable and wait for response
+func (a *Asterisk) SetVarContext(ctx context.Context, name, value, channel string) (Message, error) {
+
+	return a.SendActionContext(ctx, setVarAction(name, value, channel))
+}
+
+// createConfigAction, CreateConfig action message
+func createConfigAction(filename string) Message {
 	m := Message{
 		"Action":   "CreateConfig",
 		"Filename": filename,
 	}
 
-	return a.SendAction(m, f)
+	return m
 }
 
-// GetConfig, get Asterisk config content (category ignored for JSON), response should be handled in callback function
-func (a *Asterisk) GetConfig(filename, category string, json bool, f *func(Message)) error {
+// CreateConfig, create empty Asterisk config
+func (a *Asterisk) CreateConfig(filename string, f *func(Message)) error {
+
+	return a.SendAction(createConfigAction(filename), f)
+}
+
+// CreateConfigContext, create empty Asterisk config and wait for response
+func (a *Asterisk) CreateConfigContext(ctx context.Context, filename string) (Message, error) {
+
+	return a.SendActionContext(ctx, createConfigAction(filename))
+}
+
+// getConfigAction, GetConfig/GetConfigJSON action message
+func getConfigAction(filename, category string, json bool) Message {
 	m := Message{
 		"Filename": filename,
 	}
@@ -527,7 +1056,19 @@ func (a *Asterisk) GetConfig(filename, category string, json bool, f *func(Messa
 		}
 	}
 
-	return a.SendAction(m, f)
+	return m
+}
+
+// GetConfig, get Asterisk config content (category ignored for JSON), response should be handled in callback function
+func (a *Asterisk) GetConfig(filename, category string, json bool, f *func(Message)) error {
+
+	return a.SendAction(getConfigAction(filename, category, json), f)
+}
+
+// GetConfigContext, get Asterisk config content, returns response message
+func (a *Asterisk) GetConfigContext(ctx context.Context, filename, category string, json bool) (Message, error) {
+
+	return a.SendActionContext(ctx, getConfigAction(filename, category, json))
 }
 
 type UpdateConfigAction struct {
@@ -539,8 +1080,8 @@ type UpdateConfigAction struct {
 	Line     string
 }
 
-// UpdateConfig, modify Asterisk config
-func (a *Asterisk) Updateconfig(srcFile, dstFile, reaload string, actions []UpdateConfigAction, f *func(Message)) error {
+// updateConfigAction, UpdateConfig action message
+func updateConfigAction(srcFile, dstFile, reaload string, actions []UpdateConfigAction) Message {
 
 	cnt := 0
 	m := Message{
@@ -564,5 +1105,17 @@ func (a *Asterisk) Updateconfig(srcFile, dstFile, reaload string, actions []Upda
 		cnt++
 	}
 
-	return a.SendAction(m, f)
+	return m
+}
+
+// UpdateConfig, modify Asterisk config
+func (a *Asterisk) Updateconfig(srcFile, dstFile, reaload string, actions []UpdateConfigAction, f *func(Message)) error {
+
+	return a.SendAction(updateConfigAction(srcFile, dstFile, reaload, actions), f)
+}
+
+// UpdateconfigContext, modify Asterisk config and wait for response
+func (a *Asterisk) UpdateconfigContext(ctx context.Context, srcFile, dstFile, reaload string, actions []UpdateConfigAction) (Message, error) {
+
+	return a.SendActionContext(ctx, updateConfigAction(srcFile, dstFile, reaload, actions))
 }
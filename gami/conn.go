@@ -0,0 +1,440 @@
+package gami
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	_RECONNECT_MIN   = 500 * time.Millisecond // default first reconnect delay
+	_RECONNECT_MAX   = 30 * time.Second       // default reconnect delay limit
+	_RECONNECT_LOGIN = 10 * time.Second       // default reconnect Login limit
+)
+
+// Dialer, creates new network connection to Asterisk
+type Dialer func() (net.Conn, error)
+
+// ConnState, connection state notification
+type ConnState int
+
+const (
+	Disconnected    ConnState = iota // connection to Asterisk lost
+	Reconnected                      // connection restored and logined again
+	ReconnectFailed                  // reconnect attempts exhausted, client stopped
+)
+
+// String, Stringer interface implementation
+func (s ConnState) String() string {
+
+	switch s {
+	case Disconnected:
+		return "Disconnected"
+	case Reconnected:
+		return "Reconnected"
+	case ReconnectFailed:
+		return "ReconnectFailed"
+	}
+
+	return "Unknown"
+}
+
+// Reconnect, reconnect policy (exponential backoff between attempts)
+type Reconnect struct {
+	MinDelay     time.Duration // delay before first attempt, 0 - 500ms
+	MaxDelay     time.Duration // delay limit, 0 - 30s
+	MaxAttempts  int           // attempts limit, 0 - unlimited
+	LoginTimeout time.Duration // Login response limit of each attempt, 0 - 10s
+}
+
+// NewReconnect, Reconnect default values constructor
+func NewReconnect() *Reconnect {
+	return &Reconnect{
+		MinDelay:     _RECONNECT_MIN,
+		MaxDelay:     _RECONNECT_MAX,
+		LoginTimeout: _RECONNECT_LOGIN,
+	}
+}
+
+// delay, returns delay before attempt n (starting from 0)
+func (r *Reconnect) delay(n int) time.Duration {
+
+	d, max := r.MinDelay, r.MaxDelay
+	if d <= 0 {
+		d = _RECONNECT_MIN
+	}
+	if max <= 0 {
+		max = _RECONNECT_MAX
+	}
+
+	for i := 0; i < n && d < max; i++ {
+		d *= 2
+	}
+
+	if d > max {
+		d = max
+	}
+
+	return d
+}
+
+// loginTimeout, Login limit of reconnect attempt
+func (r *Reconnect) loginTimeout() time.Duration {
+
+	if r.LoginTimeout <= 0 {
+		return _RECONNECT_LOGIN
+	}
+
+	return r.LoginTimeout
+}
+
+// reconnector, state of client which owns a dialer
+type reconnector struct {
+	mu       *sync.Mutex
+	dial     Dialer
+	policy   *Reconnect
+	username string // credentials replayed on reconnect
+	secret   string
+	running  bool               // reconnect loop active
+	closed   bool               // logoff requested, never reconnect
+	done     chan struct{}      // closed when running reconnect loop exits
+	ctx      context.Context    // canceled by close, aborts backoff and Login of attempt
+	cancel   context.CancelFunc // cancels ctx
+}
+
+// start, marks reconnect loop started, false if already running or closed
+func (rc *reconnector) start() bool {
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.running || rc.closed {
+		return false
+	}
+	rc.running = true
+	rc.done = make(chan struct{})
+
+	return true
+}
+
+// stop, marks reconnect loop finished
+func (rc *reconnector) stop() {
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.running = false
+	close(rc.done)
+}
+
+// close, disables reconnects and aborts running attempt, returns channel closed
+// when running reconnect loop exits (nil if not running)
+func (rc *reconnector) close() chan struct{} {
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.closed = true
+	rc.cancel()
+
+	if !rc.running {
+		return nil
+	}
+
+	return rc.done
+}
+
+// setCredentials, stores credentials for next logins
+func (rc *reconnector) setCredentials(username, secret string) {
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.username, rc.secret = username, secret
+}
+
+// credentials, returns stored credentials
+func (rc *reconnector) credentials() (string, string) {
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.username, rc.secret
+}
+
+// NewReconnectAsterisk, Asterisk factory for client which owns a dialer
+// lost connection is redialed according to r (NewReconnect() if nil) and Login replayed
+// with original credentials, registered handlers are preserved
+func NewReconnectAsterisk(dial Dialer, r *Reconnect, f *func(error)) (*Asterisk, error) {
+
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	if r == nil {
+		r = NewReconnect()
+	}
+
+	a := NewAsterisk(&conn, f)
+	a.rc = &reconnector{
+		mu:     &sync.Mutex{},
+		dial:   dial,
+		policy: r,
+	}
+	a.rc.ctx, a.rc.cancel = context.WithCancel(context.Background())
+
+	return a, nil
+}
+
+// StateHandler, set connection state handler (Disconnected, Reconnected, ReconnectFailed)
+// (pointer wrapper of OnState)
+func (a *Asterisk) StateHandler(f *func(ConnState, error)) {
+
+	a.OnState(deref(f))
+}
+
+// notify, runs connection state handler if present
+func (a *Asterisk) notify(s ConnState, err error) {
+
+	a.mu.RLock()
+	f := a.stateHandler
+	a.mu.RUnlock()
+
+	if f != nil {
+		f(s, err)
+	}
+}
+
+// isAuthorized, reports if session is logged in
+func (a *Asterisk) isAuthorized() bool {
+
+	return atomic.LoadInt32(a.authorized) == 1
+}
+
+// setAuthorized, sets session state, returns previous one
+func (a *Asterisk) setAuthorized(v bool) bool {
+
+	var n int32
+	if v {
+		n = 1
+	}
+
+	return atomic.SwapInt32(a.authorized, n) == 1
+}
+
+// connection, returns current network connection
+func (a *Asterisk) connection() net.Conn {
+
+	a.connMu.RLock()
+	defer a.connMu.RUnlock()
+	return *a.conn
+}
+
+// setConnection, replaces network connection (variable passed to NewAsterisk is updated too)
+func (a *Asterisk) setConnection(conn net.Conn) {
+
+	a.connMu.Lock()
+	defer a.connMu.Unlock()
+	*a.conn = conn
+}
+
+// connLost, network error handling (called by read dispatcher of conn)
+func (a *Asterisk) connLost(conn net.Conn, err error) {
+
+	// reader of replaced or abandoned connection, session is not its
+	a.connMu.RLock()
+	stale := *a.conn != conn || a.abandoned == conn
+	a.connMu.RUnlock()
+	if stale {
+		return
+	}
+
+	wasAuthorized := a.setAuthorized(false) // unauth
+
+	// finish streams bound to this connection
+	a.mu.Lock()
+	close(a.sessionDone)
+	a.sessionDone = make(chan struct{})
+	a.mu.Unlock()
+
+	// complete all pending actions, nobody will answer them
+	for aid, f := range a.actionHandlers.drain() {
+		a.runAction(aid, f, closedMessage(aid))
+	}
+
+	if a.isClosed() { // connection closed by Close, not a network error
+		return
+	}
+
+	if a.netErrHandler != nil { // run network error callback
+		(*a.netErrHandler)(err)
+	}
+
+	if wasAuthorized {
+		a.notify(Disconnected, err)
+	}
+
+	if wasAuthorized && a.rc != nil && a.rc.start() { // only established session is restored
+		go a.reconnect()
+	}
+}
+
+// reconnect, reconnect loop, state is reported after loop is marked finished
+// (state handler may call Close, which waits for running loop)
+func (a *Asterisk) reconnect() {
+
+	err := a.redial()
+	a.rc.stop()
+
+	switch {
+	case a.rc.ctx.Err() != nil: // closed
+	case err == nil:
+		a.notify(Reconnected, nil)
+	default:
+		a.notify(ReconnectFailed, err)
+	}
+}
+
+// redial, redials Asterisk with backoff and replays Login, stops when closed
+func (a *Asterisk) redial() error {
+
+	var err error
+	for n := 0; a.rc.policy.MaxAttempts == 0 || n < a.rc.policy.MaxAttempts; n++ {
+
+		t := time.NewTimer(a.rc.policy.delay(n))
+		select {
+		case <-t.C:
+		case <-a.rc.ctx.Done(): // closed
+			t.Stop()
+			return a.rc.ctx.Err()
+		}
+
+		var conn net.Conn
+		if conn, err = a.rc.dial(); err != nil {
+			continue
+		}
+
+		a.setConnection(conn)
+
+		// server accepting TCP but not answering must not block reconnect
+		ctx, cancel := context.WithTimeout(a.rc.ctx, a.rc.policy.loginTimeout())
+		login, secret := a.rc.credentials()
+		err = a.login(ctx, login, secret)
+		cancel()
+		if err != nil {
+			a.abandon(conn)
+			continue
+		}
+
+		return nil
+	}
+
+	return err
+}
+
+// abandon, closes connection of failed reconnect attempt and waits for its reader,
+// loss of abandoned connection is not reported
+func (a *Asterisk) abandon(conn net.Conn) {
+
+	a.connMu.Lock()
+	a.abandoned = conn
+	a.connMu.Unlock()
+
+	conn.Close()
+
+	a.mu.RLock()
+	rd := a.readerDone
+	a.mu.RUnlock()
+	<-rd
+}
+
+// isClosed, reports if Close was called
+func (a *Asterisk) isClosed() bool {
+
+	select {
+	case <-a.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitContext, runs wait and blocks until it returns or ctx is done
+func waitContext(ctx context.Context, wait func()) error {
+
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+}
+
+// Close, graceful shutdown: sends Logoff and waits for Goodbye, closes connection,
+// waits for read dispatcher and running handlers (pending actions are completed with
+// ErrConnectionClosed), stops executors and disables reconnect
+// network error handler and state handler are not called, safe to call more than once
+// ctx limits whole shutdown, connection is closed anyway
+// handler must call Close in own goroutine (go a.Close(ctx)), otherwise Close waits for
+// the handler itself until ctx is done
+func (a *Asterisk) Close(ctx context.Context) error {
+
+	first := false
+	a.closeOnce.Do(func() {
+		first = true
+		close(a.closed)
+	})
+	if !first {
+		return nil
+	}
+
+	var err error
+
+	// running reconnect must not install new connection after Close
+	if a.rc != nil {
+		if rd := a.rc.close(); rd != nil {
+			err = waitContext(ctx, func() { <-rd })
+		}
+	}
+
+	if a.isAuthorized() {
+		if _, lerr := a.SendActionContext(ctx, Message{"Action": "Logoff"}); lerr != nil && err == nil { // Response: Goodbye
+			err = lerr
+		}
+	}
+
+	if cerr := a.connection().Close(); cerr != nil && err == nil && !errors.Is(cerr, net.ErrClosed) {
+		err = cerr
+	}
+
+	a.mu.RLock()
+	rd := a.readerDone
+	a.mu.RUnlock()
+
+	if rd != nil {
+		if werr := waitContext(ctx, func() { <-rd }); werr != nil && err == nil {
+			err = werr
+		}
+	}
+
+	ae, ee, _ := a.executors()
+	if werr := waitContext(ctx, func() {
+		ae.wait()
+		ee.wait()
+	}); werr != nil && err == nil {
+		err = werr
+	}
+
+	a.mu.Lock()
+	a.actionExec.stop()
+	a.eventExec.stop()
+	a.mu.Unlock()
+
+	return err
+}
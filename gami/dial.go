@@ -0,0 +1,136 @@
+package gami
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"time"
+)
+
+// DialOptions, network options for Dial and DialTLS
+type DialOptions struct {
+	ConnectTimeout time.Duration // connection establishment timeout, 0 - no timeout
+	ReadTimeout    time.Duration // max idle time between reads, 0 - no timeout (use with keepalive)
+	WriteTimeout   time.Duration // packet write deadline (Asterisk.SetWriteTimeout), 0 - default 10s
+	Reconnect      *Reconnect    // reconnect policy, nil - no reconnects
+
+	Certificates       []tls.Certificate // TLS client certificates
+	RootCAs            *x509.CertPool    // TLS server verification pool, nil - system pool
+	ServerName         string            // TLS server name, default host part of address
+	InsecureSkipVerify bool              // TLS disable server verification (testing only)
+}
+
+// timeoutConn, sets deadline before each read (write deadline is set by writer)
+type timeoutConn struct {
+	net.Conn
+	rt time.Duration
+}
+
+// Read, io.Reader implementation with deadline
+func (c *timeoutConn) Read(b []byte) (int, error) {
+
+	if c.rt > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.rt)); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.Conn.Read(b)
+}
+
+// tlsConfig, TLS client configuration for address
+func (o *DialOptions) tlsConfig(address string) (*tls.Config, error) {
+
+	sn := o.ServerName
+	if sn == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		sn = host
+	}
+
+	return &tls.Config{
+		Certificates:       o.Certificates,
+		RootCAs:            o.RootCAs,
+		ServerName:         sn,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}, nil
+}
+
+// dialer, returns Dialer for address (tls config nil for plain TCP)
+func (o *DialOptions) dialer(address string, tc *tls.Config) Dialer {
+
+	return func() (net.Conn, error) {
+		d := &net.Dialer{Timeout: o.ConnectTimeout}
+
+		var conn net.Conn
+		var err error
+		if tc != nil {
+			conn, err = tls.DialWithDialer(d, "tcp", address, tc)
+		} else {
+			conn, err = d.Dial("tcp", address)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if o.ReadTimeout > 0 {
+			conn = &timeoutConn{conn, o.ReadTimeout}
+		}
+
+		return conn, nil
+	}
+}
+
+// newDialed, connects and creates Asterisk
+func newDialed(d Dialer, o *DialOptions, f *func(error)) (*Asterisk, error) {
+
+	var a *Asterisk
+	if o.Reconnect != nil {
+		var err error
+		if a, err = NewReconnectAsterisk(d, o.Reconnect, f); err != nil {
+			return nil, err
+		}
+	} else {
+		conn, err := d()
+		if err != nil {
+			return nil, err
+		}
+		a = NewAsterisk(&conn, f)
+	}
+
+	if o.WriteTimeout > 0 {
+		a.SetWriteTimeout(o.WriteTimeout)
+	}
+
+	return a, nil
+}
+
+// Dial, connects to AMI (host:port, usually 5038) and returns Asterisk ready for Login
+// o may be nil for default options, f is network error callback
+func Dial(address string, o *DialOptions, f *func(error)) (*Asterisk, error) {
+
+	if o == nil {
+		o = &DialOptions{}
+	}
+
+	return newDialed(o.dialer(address, nil), o, f)
+}
+
+// DialTLS, connects to AMI over TLS (host:port, usually 5039) and returns Asterisk ready for Login
+// o may be nil for default options, f is network error callback
+func DialTLS(address string, o *DialOptions, f *func(error)) (*Asterisk, error) {
+
+	if o == nil {
+		o = &DialOptions{}
+	}
+
+	tc, err := o.tlsConfig(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDialed(o.dialer(address, tc), o, f)
+}
@@ -0,0 +1,141 @@
+package gami
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+// selfSigned, generates certificate valid for localhost (server and client auth)
+func selfSigned(c *check.C) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, check.IsNil)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	c.Assert(err, check.IsNil)
+	crt, err := x509.ParseCertificate(der)
+	c.Assert(err, check.IsNil)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(crt)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: crt}, pool
+}
+
+// tlsMock, starts TLS Asterisk mock requiring client certificate
+func tlsMock(c *check.C, crt tls.Certificate, pool *x509.CertPool) net.Listener {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{crt},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	c.Assert(err, check.IsNil)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go servePipe(conn, func(m Message) []Message {
+				if m["Action"] == "Ping" {
+					return []Message{{"Response": "Success", "Ping": "Pong"}}
+				}
+				return nil
+			})
+		}
+	}()
+
+	return l
+}
+
+func (s *UnitSuite) TestDialTLS(c *check.C) {
+	crt, pool := selfSigned(c)
+	l := tlsMock(c, crt, pool)
+	defer l.Close()
+
+	a, err := DialTLS(l.Addr().String(), &DialOptions{
+		ConnectTimeout: time.Second,
+		ReadTimeout:    time.Second,
+		WriteTimeout:   time.Second,
+		Certificates:   []tls.Certificate{crt},
+		RootCAs:        pool,
+		ServerName:     "localhost",
+	}, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(a.wr.timeout, check.Equals, time.Second) // single write deadline mechanism
+	c.Assert(a.Login("admin", "admin"), check.IsNil)
+
+	r, err := a.SendActionContext(context.Background(), Message{"Action": "Ping"})
+	c.Assert(err, check.IsNil)
+	c.Assert(r["Ping"], check.Equals, "Pong")
+	a.connection().Close()
+}
+
+func (s *UnitSuite) TestDialTLSVerify(c *check.C) {
+	crt, pool := selfSigned(c)
+	l := tlsMock(c, crt, pool)
+	defer l.Close()
+
+	// server certificate not trusted
+	_, err := DialTLS(l.Addr().String(), &DialOptions{ConnectTimeout: time.Second}, nil)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *UnitSuite) TestDialTimeout(c *check.C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+	go servePipeListener(l)
+
+	errc := make(chan error, 1)
+	f := func(err error) {
+		errc <- err
+	}
+
+	a, err := Dial(l.Addr().String(), &DialOptions{ReadTimeout: 20 * time.Millisecond}, &f)
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Login("admin", "admin"), check.IsNil)
+
+	// nothing to read, idle connection must fail with timeout
+	select {
+	case err := <-errc:
+		ne, ok := err.(net.Error)
+		c.Assert(ok && ne.Timeout(), check.Equals, true)
+	case <-time.After(time.Second):
+		c.Fatal("read timeout not reported")
+	}
+}
+
+// servePipeListener, serves plain TCP connections with default mock
+func servePipeListener(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go servePipe(conn, func(Message) []Message { return nil })
+	}
+}
@@ -0,0 +1,484 @@
+package gami
+
+import (
+	"hash/fnv"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+)
+
+// DispatchMode, handlers execution mode
+type DispatchMode int
+
+const (
+	DispatchConcurrent DispatchMode = iota // every handler in own goroutine, no order (default)
+	DispatchOrdered                        // handlers run one by one in receive order
+	DispatchKeyed                          // in receive order per key, different keys in parallel
+)
+
+// KeyFunc, returns serialization key for message (DispatchKeyed mode)
+type KeyFunc func(Message) string
+
+// HeaderKey, KeyFunc using header value as key ("Uniqueid", "Linkedid" ...)
+// messages without header share empty key
+func HeaderKey(header string) KeyFunc {
+
+	return func(m Message) string {
+		return m[header]
+	}
+}
+
+// QueuePolicy, worker pool behavior when queue is full
+type QueuePolicy int
+
+const (
+	QueueBlock      QueuePolicy = iota // reader waits for free slot (backpressure to socket)
+	QueueDropOldest                    // oldest queued job is dropped
+	QueueDropNewest                    // received message is dropped
+)
+
+// msgDrop, counts message once however many of its jobs are dropped
+type msgDrop struct {
+	done    *int32  // message counted (1), atomic
+	counter *uint64 // dropped messages counter
+}
+
+// newMsgDrop, msgDrop factory
+func newMsgDrop(counter *uint64) *msgDrop {
+
+	return &msgDrop{new(int32), counter}
+}
+
+// drop, counts message if not counted yet
+func (d *msgDrop) drop() {
+
+	if d != nil && atomic.CompareAndSwapInt32(d.done, 0, 1) {
+		atomic.AddUint64(d.counter, 1)
+	}
+}
+
+// job, handler run for received message
+type job struct {
+	f func()
+	d *msgDrop // message of job (nil - drops not counted)
+}
+
+// executor, runs handler jobs
+type executor interface {
+	run(key string, j job)
+	wait() // blocks until all submitted jobs are finished (or dropped)
+	stop()
+}
+
+// goExecutor, runs every job in new goroutine
+type goExecutor struct {
+	wg *sync.WaitGroup // running jobs
+}
+
+// newGoExecutor, goExecutor factory
+func newGoExecutor() goExecutor {
+
+	return goExecutor{&sync.WaitGroup{}}
+}
+
+// run, executor implementation
+func (ge goExecutor) run(key string, j job) {
+
+	ge.wg.Add(1)
+	go func() {
+		defer ge.wg.Done()
+		j.f()
+	}()
+}
+
+// wait, executor implementation
+func (ge goExecutor) wait() {
+
+	ge.wg.Wait()
+}
+
+// stop, executor implementation
+func (goExecutor) stop() {}
+
+// keyedExecutor, runs jobs in order per key, different keys in parallel
+type keyedExecutor struct {
+	mu *sync.Mutex
+	q  map[string][]func() // pending jobs, key present while its worker runs
+	wg *sync.WaitGroup     // pending and running jobs
+}
+
+// newKeyedExecutor, keyedExecutor factory
+func newKeyedExecutor() *keyedExecutor {
+
+	return &keyedExecutor{
+		mu: &sync.Mutex{},
+		q:  make(map[string][]func()),
+		wg: &sync.WaitGroup{},
+	}
+}
+
+// run, executor implementation
+func (ke *keyedExecutor) run(key string, j job) {
+
+	ke.mu.Lock()
+	defer ke.mu.Unlock()
+
+	ke.wg.Add(1)
+
+	if l, ok := ke.q[key]; ok { // worker running, just enqueue
+		ke.q[key] = append(l, j.f)
+		return
+	}
+
+	ke.q[key] = nil
+	go ke.work(key, j.f)
+}
+
+// wait, executor implementation
+func (ke *keyedExecutor) wait() {
+
+	ke.wg.Wait()
+}
+
+// stop, executor implementation
+func (ke *keyedExecutor) stop() {}
+
+// work, runs jobs for key until queue is empty
+func (ke *keyedExecutor) work(key string, f func()) {
+
+	for {
+		f()
+		ke.wg.Done()
+
+		ke.mu.Lock()
+		l := ke.q[key]
+		if len(l) == 0 {
+			delete(ke.q, key)
+			ke.mu.Unlock()
+			return
+		}
+		f, ke.q[key] = l[0], l[1:]
+		ke.mu.Unlock()
+	}
+}
+
+// poolExecutor, fixed number of workers with bounded queues
+// keyed pool sends jobs with same key to same worker (order per key)
+// dropped jobs are counted by their messages (job.d)
+type poolExecutor struct {
+	q      []chan job // worker queues (single shared queue if not keyed)
+	policy QueuePolicy
+	wg     *sync.WaitGroup // queued and running jobs
+	done   chan struct{}
+	once   *sync.Once // stops once
+}
+
+// newPoolExecutor, starts workers
+func newPoolExecutor(workers, queue int, policy QueuePolicy, keyed bool) *poolExecutor {
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	pe := &poolExecutor{
+		policy: policy,
+		wg:     &sync.WaitGroup{},
+		done:   make(chan struct{}),
+		once:   &sync.Once{},
+	}
+
+	if keyed {
+		for i := 0; i < workers; i++ {
+			q := make(chan job, queue)
+			pe.q = append(pe.q, q)
+			go pe.work(q)
+		}
+	} else {
+		q := make(chan job, queue)
+		pe.q = append(pe.q, q)
+		for i := 0; i < workers; i++ {
+			go pe.work(q)
+		}
+	}
+
+	return pe
+}
+
+// work, runs jobs from queue until stopped
+func (pe *poolExecutor) work(q chan job) {
+
+	for {
+		select {
+		case j := <-q:
+			j.f()
+			pe.wg.Done()
+		case <-pe.done:
+			return
+		}
+	}
+}
+
+// run, executor implementation
+func (pe *poolExecutor) run(key string, j job) {
+
+	q := pe.q[0]
+	if len(pe.q) > 1 {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		q = pe.q[h.Sum32()%uint32(len(pe.q))]
+	}
+
+	pe.wg.Add(1)
+
+	switch pe.policy {
+	case QueueDropNewest:
+		select {
+		case q <- j:
+		default:
+			j.d.drop()
+			pe.wg.Done()
+		}
+	case QueueDropOldest:
+		for {
+			select {
+			case q <- j:
+				return
+			default:
+			}
+			select {
+			case oj := <-q:
+				oj.d.drop()
+				pe.wg.Done()
+			default:
+			}
+		}
+	default:
+		select {
+		case q <- j:
+		case <-pe.done:
+			pe.wg.Done()
+		}
+	}
+}
+
+// wait, executor implementation
+func (pe *poolExecutor) wait() {
+
+	pe.wg.Wait()
+}
+
+// stop, executor implementation (queued jobs are discarded, safe to call more than once)
+func (pe *poolExecutor) stop() {
+
+	pe.once.Do(func() {
+		close(pe.done)
+		for _, q := range pe.q {
+			for discard := true; discard; {
+				select {
+				case <-q:
+					pe.wg.Done()
+				default:
+					discard = false
+				}
+			}
+		}
+	})
+}
+
+// setExecutors, builds executors for current dispatch settings (a.mu must be held)
+func (a *Asterisk) setExecutors() {
+
+	a.actionExec.stop()
+	a.eventExec.stop()
+
+	keyed := a.dispatchMode != DispatchConcurrent
+
+	// action callbacks are always ordered per ActionID (list responses must not overtake each other)
+	// and never run on pool, callback waiting for nested action response must not block its worker
+	a.actionExec = newKeyedExecutor()
+
+	if a.poolWorkers > 0 {
+		a.eventExec = newPoolExecutor(a.poolWorkers, a.poolQueue, a.poolPolicy, keyed)
+	} else if keyed {
+		a.eventExec = newKeyedExecutor()
+	} else {
+		a.eventExec = newGoExecutor()
+	}
+}
+
+// SetDispatchMode, set handlers execution mode (should be called before Login)
+// key used by DispatchKeyed mode only (nil - HeaderKey("Uniqueid"))
+// action callbacks are always serialized per ActionID, independently of events,
+// so handler may wait for action response without deadlock
+func (a *Asterisk) SetDispatchMode(mode DispatchMode, key KeyFunc) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch mode {
+	case DispatchOrdered:
+		a.eventKey = nil
+	case DispatchKeyed:
+		if key == nil {
+			key = HeaderKey("Uniqueid")
+		}
+		a.eventKey = key
+	default:
+		mode = DispatchConcurrent
+		a.eventKey = nil
+	}
+
+	a.dispatchMode = mode
+	a.setExecutors()
+}
+
+// SetWorkerPool, run handlers on fixed number of workers with bounded queue (should be called before Login)
+// in ordered modes every worker has own queue and messages with same key go to same worker,
+// policy is applied to event and default handlers, action callbacks do not use pool,
+// with QueueBlock handler waiting for action response stalls reading when queue is full,
+// workers 0 - goroutine per handler (default)
+func (a *Asterisk) SetWorkerPool(workers, queue int, policy QueuePolicy) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.poolWorkers = workers
+	a.poolQueue = queue
+	a.poolPolicy = policy
+	a.setExecutors()
+}
+
+// PoolDropped, returns number of messages dropped by worker pool (message is counted once
+// however many of its handlers are dropped)
+func (a *Asterisk) PoolDropped() uint64 {
+
+	return atomic.LoadUint64(a.poolDropped)
+}
+
+// StreamDropped, returns number of events dropped by Events streams (every stream counts
+// own losses)
+func (a *Asterisk) StreamDropped() uint64 {
+
+	return atomic.LoadUint64(a.streamDropped)
+}
+
+// executors, returns action and event executors and event key function
+func (a *Asterisk) executors() (executor, executor, KeyFunc) {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.actionExec, a.eventExec, a.eventKey
+}
+
+// ErrorHandler, set hook for handler failures (*HandlerPanic), logged if not set
+// (pointer wrapper of OnError)
+func (a *Asterisk) ErrorHandler(f *func(error)) {
+
+	a.OnError(deref(f))
+}
+
+// call, runs handler for m, panic is recovered and reported to error hook
+func (a *Asterisk) call(f func(), m Message) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			a.reportError(&HandlerPanic{
+				Event:    m["Event"],
+				ActionID: m["ActionID"],
+				Value:    r,
+				Stack:    debug.Stack(),
+			})
+		}
+	}()
+
+	f()
+}
+
+// reportError, runs error hook or logs error
+func (a *Asterisk) reportError(err error) {
+
+	a.mu.RLock()
+	f := a.errHandler
+	a.mu.RUnlock()
+
+	if f == nil {
+		log.Printf("%s", err)
+		return
+	}
+
+	f(err)
+}
+
+// runAction, runs action callback
+func (a *Asterisk) runAction(aid string, f *func(Message), m Message) {
+
+	ae, _, _ := a.executors()
+	ae.run(aid, job{f: func() {
+		a.call(func() { (*f)(m) }, m)
+	}})
+}
+
+// RawHandler, set handler for all Asterisk packets with headers in wire order
+// (pointer wrapper of OnRaw)
+func (a *Asterisk) RawHandler(f *func(Headers)) {
+
+	a.OnRaw(deref(f))
+}
+
+// dispatch, runs all handlers for received packet
+func (a *Asterisk) dispatch(h Headers) {
+
+	m := h.Message()
+
+	// if has ActionID and has callback run it and delete
+	if v, vok := m["ActionID"]; vok {
+		if f, sd := a.actionHandlers.get(v); f != nil {
+			a.runAction(v, f, m)
+			if !sd { // will never remove "self-delete" callbacks
+				a.actionHandlers.del(v)
+			}
+		}
+	}
+
+	_, ee, kf := a.executors()
+	key := ""
+	if kf != nil {
+		key = kf(m)
+	}
+	d := newMsgDrop(a.poolDropped) // jobs of this message
+
+	// if Event run its subscriptions
+	if _, vok := m["Event"]; vok {
+		for _, s := range a.subscriptions.match(m) {
+			f := s.f
+			if f == nil {
+				continue
+			}
+			if s.direct {
+				a.call(func() { f(m) }, m)
+				continue
+			}
+			ee.run(key, job{func() {
+				a.call(func() { f(m) }, m)
+			}, d})
+		}
+	}
+
+	a.mu.RLock()
+	df, rf := a.defaultHandler, a.rawHandler
+	a.mu.RUnlock()
+
+	// run default handler if not nil
+	if df != nil {
+		ee.run(key, job{func() {
+			a.call(func() { df(m) }, m)
+		}, d})
+	}
+
+	if rf != nil {
+		ee.run(key, job{func() {
+			a.call(func() { rf(h) }, m)
+		}, d})
+	}
+}
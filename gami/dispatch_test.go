@@ -0,0 +1,233 @@
+package gami
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *UnitSuite) TestDispatchOrdered(c *check.C) {
+	cln, srv := newPipe(c, func(m Message) []Message {
+		return []Message{{"Response": "Success"}}
+	})
+	defer srv.Close()
+
+	a := NewAsterisk(&cln, nil)
+	a.SetDispatchMode(DispatchOrdered, nil)
+	c.Assert(a.Login("admin", "admin"), check.IsNil)
+
+	ch := make(chan Message, 100)
+	h := func(m Message) {
+		if m["Seq"] == "0" {
+			// slow first handler, waits for action response
+			time.Sleep(20 * time.Millisecond)
+			_, err := a.SendActionContext(context.Background(), Message{"Action": "Ping"})
+			c.Check(err, check.IsNil)
+		}
+		ch <- m
+	}
+	a.Subscribe("Newstate", &h)
+
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(srv, "Event: Newstate\r\nSeq: %d\r\n\r\n", i)
+	}
+
+	for i, m := range expectMessages(c, ch, 50) {
+		c.Assert(m["Seq"], check.Equals, fmt.Sprint(i))
+	}
+}
+
+func (s *UnitSuite) TestDispatchKeyed(c *check.C) {
+	cln, srv := newPipe(c, func(Message) []Message { return nil })
+	defer srv.Close()
+
+	a := NewAsterisk(&cln, nil)
+	a.SetDispatchMode(DispatchKeyed, HeaderKey("Linkedid"))
+	c.Assert(a.Login("admin", "admin"), check.IsNil)
+
+	block := make(chan struct{})
+	ch := make(chan Message, 100)
+	h := func(m Message) {
+		if m["Linkedid"] == "1" && m["Seq"] == "0" {
+			<-block
+		}
+		ch <- m
+	}
+	a.Subscribe("Newstate", &h)
+
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(srv, "Event: Newstate\r\nLinkedid: 1\r\nSeq: %d\r\n\r\n", i)
+		fmt.Fprintf(srv, "Event: Newstate\r\nLinkedid: 2\r\nSeq: %d\r\n\r\n", i)
+	}
+
+	// key 2 not blocked by key 1
+	for i, m := range expectMessages(c, ch, 10) {
+		c.Assert(m["Linkedid"], check.Equals, "2")
+		c.Assert(m["Seq"], check.Equals, fmt.Sprint(i))
+	}
+
+	close(block)
+	for i, m := range expectMessages(c, ch, 10) {
+		c.Assert(m["Linkedid"], check.Equals, "1")
+		c.Assert(m["Seq"], check.Equals, fmt.Sprint(i))
+	}
+}
+
+// fillPool, blocks single worker and submits n jobs (same message if shared), returns ids
+// of executed jobs and number of dropped messages
+func fillPool(c *check.C, policy QueuePolicy, n int, shared bool) ([]int, uint64) {
+	dropped := new(uint64)
+	pe := newPoolExecutor(1, 2, policy, false)
+	defer pe.stop()
+
+	started := make(chan struct{})
+	block := make(chan struct{})
+	pe.run("", job{f: func() {
+		close(started)
+		<-block
+	}})
+	<-started
+
+	done := make(chan int, n)
+	d := newMsgDrop(dropped)
+	for i := 0; i < n; i++ {
+		i := i
+		if !shared {
+			d = newMsgDrop(dropped)
+		}
+		pe.run("", job{func() { done <- i }, d})
+	}
+	close(block)
+
+	ids := []int{}
+	for {
+		select {
+		case i := <-done:
+			ids = append(ids, i)
+		case <-time.After(50 * time.Millisecond):
+			return ids, *dropped
+		}
+	}
+}
+
+func (s *UnitSuite) TestPoolPolicies(c *check.C) {
+	ids, dropped := fillPool(c, QueueDropNewest, 5, false)
+	c.Assert(ids, check.DeepEquals, []int{0, 1})
+	c.Assert(dropped, check.Equals, uint64(3))
+
+	ids, dropped = fillPool(c, QueueDropOldest, 5, false)
+	c.Assert(ids, check.DeepEquals, []int{3, 4})
+	c.Assert(dropped, check.Equals, uint64(3))
+
+	// several handlers of one message are counted as one dropped message
+	ids, dropped = fillPool(c, QueueDropNewest, 5, true)
+	c.Assert(ids, check.DeepEquals, []int{0, 1})
+	c.Assert(dropped, check.Equals, uint64(1))
+
+	ids, dropped = fillPool(c, QueueDropOldest, 5, true)
+	c.Assert(ids, check.DeepEquals, []int{3, 4})
+	c.Assert(dropped, check.Equals, uint64(1))
+
+	cnt := new(uint64)
+	pe := newPoolExecutor(2, 1, QueueBlock, true)
+	defer pe.stop()
+	done := make(chan int, 100)
+	for i := 0; i < 100; i++ {
+		i := i
+		pe.run(fmt.Sprint(i%3), job{func() { done <- i }, newMsgDrop(cnt)})
+	}
+	for i := 0; i < 100; i++ {
+		<-done
+	}
+	c.Assert(*cnt, check.Equals, uint64(0))
+}
+
+func (s *UnitSuite) TestWorkerPoolKeyed(c *check.C) {
+	cln, srv := newPipe(c, func(Message) []Message { return nil })
+	defer srv.Close()
+
+	a := NewAsterisk(&cln, nil)
+	a.SetDispatchMode(DispatchKeyed, nil)
+	a.SetWorkerPool(4, 10, QueueBlock)
+	c.Assert(a.Login("admin", "admin"), check.IsNil)
+
+	ch := make(chan Message, 300)
+	h := func(m Message) { ch <- m }
+	a.Subscribe("VarSet", &h)
+
+	for i := 0; i < 100; i++ {
+		for k := 0; k < 3; k++ {
+			fmt.Fprintf(srv, "Event: VarSet\r\nUniqueid: %d\r\nSeq: %d\r\n\r\n", k, i)
+		}
+	}
+
+	next := map[string]int{}
+	for _, m := range expectMessages(c, ch, 300) {
+		c.Assert(m["Seq"], check.Equals, fmt.Sprint(next[m["Uniqueid"]]))
+		next[m["Uniqueid"]]++
+	}
+	c.Assert(a.PoolDropped(), check.Equals, uint64(0))
+}
+
+func (s *UnitSuite) TestWorkerPoolNested(c *check.C) {
+	cln, srv := newPipe(c, func(m Message) []Message {
+		if m["Action"] == "Ping" {
+			return []Message{{"Response": "Success", "Ping": "Pong"}}
+		}
+		return nil
+	})
+	defer srv.Close()
+
+	a := NewAsterisk(&cln, nil)
+	a.SetWorkerPool(1, 4, QueueBlock)
+	c.Assert(a.Login("admin", "admin"), check.IsNil)
+
+	// callback waits for response of another action
+	errc := make(chan error, 1)
+	f := func(Message) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := a.SendActionContext(ctx, Message{"Action": "Ping"})
+		errc <- err
+	}
+	c.Assert(a.SendAction(Message{"Action": "Ping"}, &f), check.IsNil)
+	c.Assert(<-errc, check.IsNil)
+
+	// same from event handler on pool worker
+	a.On("Nested", func(Message) { f(nil) })
+	srv.Write([]byte("Event: Nested\r\n\r\n"))
+	c.Assert(<-errc, check.IsNil)
+}
+
+func (s *UnitSuite) TestHandlerPanic(c *check.C) {
+	a, srv := newPipeAsterisk(c, func(m Message) []Message {
+		return []Message{{"Response": "Success"}}
+	})
+	defer srv.Close()
+
+	errc := make(chan error, 2)
+	eh := func(err error) { errc <- err }
+	a.ErrorHandler(&eh)
+
+	h := func(m Message) { panic("handler failed") }
+	a.Subscribe("Hangup", &h)
+	srv.Write([]byte("Event: Hangup\r\nChannel: SIP/1\r\n\r\n"))
+
+	var hp *HandlerPanic
+	c.Assert(errors.As(<-errc, &hp), check.Equals, true)
+	c.Assert(hp.Event, check.Equals, "Hangup")
+	c.Assert(hp.Value, check.Equals, "handler failed")
+	c.Assert(len(hp.Stack) > 0, check.Equals, true)
+
+	m := Message{"Action": "Ping"}
+	c.Assert(a.SendAction(m, &h), check.IsNil)
+	c.Assert(errors.As(<-errc, &hp), check.Equals, true)
+	c.Assert(hp.ActionID, check.Equals, m["ActionID"])
+
+	// connection still alive
+	_, err := a.SendActionContext(context.Background(), Message{"Action": "Ping"})
+	c.Assert(err, check.IsNil)
+}
@@ -1,8 +1,11 @@
 /*
  Package gami implements simple Asterisk Manager Interface library.
 
- It's not handle any network layer, just parsing or creating packets
- and runs callback for it (if registered).
+ It's not required to use built-in network layer, any net.Conn could be passed,
+ library is parsing or creating packets and runs callback for it (if registered).
+
+ Asterisk is safe for concurrent use, actions and handlers could be sent and changed
+ from any goroutine.
 
  Start working:
 
@@ -18,6 +21,53 @@
     // login error handling
   }
 
+  a.SetAuthType(gami.AuthMD5) // (before Login) challenge-response, secret never sent in cleartext
+
+ AMI version:
+
+  a.RequireVersion(gami.Version{Major: 2}) // (before Login) Login fails with gami.ErrUnsupportedVersion
+  ...
+  fmt.Println(a.Version()) // parsed from banner, 5.0.x for Asterisk 16
+
+ Reconnecting client:
+
+  Client created with dialer reconnects with exponential backoff, replays Login with
+  original credentials and keeps all registered handlers. Login of each attempt is limited
+  by Reconnect.LoginTimeout, zero Reconnect fields mean defaults (see NewReconnect).
+
+  dial := func() (net.Conn, error) {
+    return net.Dial("tcp", "astserver:5038")
+  }
+  a, err := gami.NewReconnectAsterisk(dial, gami.NewReconnect(), nil)
+  ...
+  sh := func(s gami.ConnState, err error) {
+    fmt.Println(s, err) // Disconnected, Reconnected, ReconnectFailed
+  }
+  a.StateHandler(&sh)
+  err = a.Login("user", "password")
+
+ Keepalive:
+
+  a.SetKeepalive(10*time.Second, 3*time.Second) // (before Login) Ping interval and Pong timeout
+  ...
+  fmt.Println(a.Latency()) // round-trip time of last Ping
+
+  Missed Pong drops connection, network error callback gets gami.ErrKeepalive and reconnecting
+  client starts reconnect.
+
+ Built-in dialing (optional):
+
+  a, err := gami.Dial("astserver:5038", &gami.DialOptions{ConnectTimeout: 5 * time.Second}, nil)
+  ...
+  crt, err := tls.LoadX509KeyPair("client.crt", "client.key")
+  a, err = gami.DialTLS("astserver:5039", &gami.DialOptions{
+    Certificates: []tls.Certificate{crt}, // client certificate
+    RootCAs:      pool,                   // server verification, nil - system pool
+    Reconnect:    gami.NewReconnect(),    // nil - no reconnects
+  }, nil)
+  ...
+  err = a.Login("user", "password")
+
  Placing simple command:
 
   ping := gami.Message{"Action":"Ping"} // ActionID will be overwritten
@@ -28,6 +78,89 @@
   }
   a.SendAction(ping, &pingCallback) // callback will be automatically executed and deleted
 
+ Waiting for response:
+
+  ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+  defer cancel()
+  r, err := a.SendActionContext(ctx, gami.Message{"Action": "Ping"}) // Response: Error returned as err
+  ...
+  _, err = a.HangupContext(ctx, "SIP/1234-00000001") // each helper has Context variant
+
+ Writing packets:
+
+  Actions could be sent from any goroutine, packets are written by single writer (queued packets
+  are coalesced into one write). Failed write returns error to caller and closes connection.
+
+  a.SetWriteTimeout(5 * time.Second) // deadline for each write, 0 - no deadline, default 10s
+
+  Context methods stop waiting for writer when ctx is done (still queued packet is dropped).
+
+ Errors:
+
+  Asterisk error responses are returned as *gami.AMIError, failures of client itself
+  as sentinel errors (gami.ErrNotAuthorized, gami.ErrConnectionClosed, gami.ErrTimeout ...)
+
+  var ae *gami.AMIError
+  if errors.As(err, &ae) {
+    fmt.Println(ae.ActionID, ae.Message)
+  } else if errors.Is(err, gami.ErrTimeout) {
+    // retry
+  }
+
+  Pointer callbacks of actions pending on lost connection get Response: Error message made
+  by client, gami.ResponseError tells it from Asterisk error:
+
+  err := gami.ResponseError(m) // nil, gami.ErrConnectionClosed or *gami.AMIError
+
+ Headers order and repeated headers:
+
+  Message is a map view of packet, repeated headers (ChanVariable, Output ...) are joined by "\n".
+  Sending works the same way: "\n" in Message value always means repeated header, single value
+  can not carry line breaks (MessageSend sends multi-line body as Base64Body).
+
+  vars := m.Values("ChanVariable") // all values in received order
+
+  Packets with exact headers order:
+
+  r, err := a.SendHeadersContext(ctx, gami.Headers{{"Action", "UserEvent"}, {"UserEvent", "Test"}})
+  rh := func(h gami.Headers) {
+    fmt.Println(h) // every received packet in wire order
+  }
+  a.RawHandler(&rh)
+
+ CLI commands:
+
+  lines, err := a.CommandOutput(ctx, "core show channels") // same result for Asterisk 1.6 and 16
+
+ List actions:
+
+  // events between EventList start and Complete, in order; Response: Error returned as *gami.AMIError
+  channels, err := a.CollectList(ctx, gami.Message{"Action": "CoreShowChannels"})
+
+ Func-value handlers:
+
+  Handlers could be passed as plain functions, registrations are removed by returned handles
+  (pointer based methods are kept for compatibility).
+
+  h, err := a.Send(gami.Message{"Action": "Ping"}, func(m gami.Message) { ... }) // h.Cancel() - ignore response
+  sub := a.On("Hangup", func(m gami.Message) { ... })                         // sub.Unsubscribe()
+  a.OnMessage(func(m gami.Message) { ... })                                    // OnRaw, OnError, OnState, nil - remove
+
+ Typed messages:
+
+  type Hangup struct {
+    Channel  string
+    State    int               `ami:"ChannelState"`
+    Cause    int               `ami:"Cause"`
+    Answered bool              `ami:"Answered"`  // yes/no, true/false, 1/0, on/off
+    Duration time.Duration     `ami:"Duration"`  // seconds, HH:MM:SS
+    Time     time.Time         `ami:"Timestamp"` // Unix timestamp, RFC3339
+    Other    map[string]string `ami:",rest"`     // headers not decoded into fields
+  }
+
+  var h Hangup
+  err := gami.Unmarshal(m, &h)
+
  Placing a call:
 
   o := gami.NewOriginateApp("SIP/1234", "Playback", "hello-world")
@@ -43,6 +176,65 @@
   ...
   a.UnregisterHandler("Hangup")
 
+ Event subscriptions:
+
+  Any number of subscriptions per event, each removed by own handle.
+
+  sub := a.Subscribe("Hangup", &hangupHandler)
+  ...
+  sub.Unsubscribe()
+
+  Event name patterns and header predicates:
+
+  sub, err := a.SubscribeFilter(&gami.EventFilter{
+    Event: "Queue*", // glob, "*" matches "/" too, empty - any event
+    Match: []gami.Predicate{gami.HeaderPrefix("Channel", "SIP/100-")},
+  }, &handler)
+
+ Event mask and filters:
+
+  a.SetEventMask("call,system")                            // (before Login) Events header of Login
+  err := a.EventMask(ctx, "off")                           // change mask of running session
+  err = a.AddFilter(ctx, "Event: Newchannel|Hangup")       // server side filter, kept for next logins
+
+ Event streams:
+
+  ch, err := a.Events(ctx, &gami.EventFilter{Event: "Hangup"}) // nil filter - all events
+  for m := range ch { // closed when ctx is done or connection lost
+    ...
+  }
+
+  Slow consumer: stream buffer (default 256) is filled, then new events are dropped and
+  counted by a.StreamDropped(), behavior is changed by a.SetStreamBuffer(size, policy).
+  Streams keep receive order in any dispatch mode.
+
+ Ordered delivery:
+
+  By default each handler runs in own goroutine, so order is not guaranteed.
+
+  a.SetDispatchMode(gami.DispatchOrdered, nil)                     // all events in receive order
+  a.SetDispatchMode(gami.DispatchKeyed, gami.HeaderKey("Linkedid")) // in order per call, calls in parallel
+
+ Worker pool:
+
+  Handlers could run on fixed number of workers with bounded queue instead of goroutine per handler.
+
+  a.SetWorkerPool(16, 1024, gami.QueueDropOldest) // QueueBlock, QueueDropOldest, QueueDropNewest
+  ...
+  fmt.Println(a.PoolDropped()) // messages dropped because of full queue
+
+ Handler failures:
+
+  Panics in callbacks and handlers are recovered, connection stays alive.
+
+  eh := func(err error) {
+    var hp *gami.HandlerPanic
+    if errors.As(err, &hp) {
+      log.Println(hp.Event, hp.ActionID, hp.Value, string(hp.Stack))
+    }
+  }
+  a.ErrorHandler(&eh) // without hook panics are logged
+
  Default handler:
 
   This handler will execute for each message received from Asterisk, useful for debugging.
@@ -78,6 +270,15 @@
 
   a.Logoff() // if a created with network error callback it will be executed
 
+  Graceful shutdown, waits for Goodbye and running handlers, closes connection:
+
+  err := a.Close(ctx) // network error callback is not executed, no reconnect
+
+  Running reconnect is canceled. Handler must call Close in own goroutine, Close waits for
+  running handlers:
+
+  a.On("Shutdown", func(gami.Message) { go a.Close(ctx) })
+
 */
 package gami
 
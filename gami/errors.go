@@ -0,0 +1,86 @@
+package gami
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotAuthorized      = errors.New("gami: not authorized")                       // action sent before successful login
+	ErrConnectionClosed   = errors.New("gami: connection closed")                    // connection lost before response
+	ErrTimeout            = errors.New("gami: timeout")                              // response not received in time
+	ErrNilCallback        = errors.New("gami: nil callback, use SendAction instead") // HoldCallbackAction without callback
+	ErrHandlerExists      = errors.New("gami: handler already exists")               // RegisterHandler for busy event
+	ErrPacketTooLarge     = errors.New("gami: packet too large")                     // received packet skipped
+	ErrUnsupportedVersion = errors.New("gami: unsupported AMI version")              // Asterisk lower than required
+	ErrKeepalive          = errors.New("gami: keepalive failed")                     // Ping not answered, connection dropped
+	ErrUnmarshal          = errors.New("gami: cannot unmarshal")                     // Unmarshal target or header value invalid
+)
+
+// AMIError, error response received from Asterisk
+type AMIError struct {
+	Response string // Response header value
+	Message  string // Message header value
+	ActionID string // action id of failed action
+}
+
+// Error, error interface implementation
+func (e *AMIError) Error() string {
+
+	if e.Message == "" {
+		return fmt.Sprintf("gami: action %s failed: %s", e.ActionID, e.Response)
+	}
+
+	return fmt.Sprintf("gami: action %s failed: %s", e.ActionID, e.Message)
+}
+
+// newAMIError, *AMIError from response message
+func newAMIError(m Message) *AMIError {
+
+	return &AMIError{
+		Response: m["Response"],
+		Message:  m["Message"],
+		ActionID: m["ActionID"],
+	}
+}
+
+// ResponseError, error of action response passed to callback: ErrConnectionClosed if connection
+// was lost before response, *AMIError for Response: Error message, nil otherwise
+func ResponseError(m Message) error {
+
+	if isClosedMessage(m) {
+		return ErrConnectionClosed
+	}
+
+	if m["Response"] != "Error" {
+		return nil
+	}
+
+	return newAMIError(m)
+}
+
+// contextError, converts ctx error, deadline is reported as ErrTimeout
+// (errors.Is matches context.DeadlineExceeded too)
+func contextError(ctx context.Context) error {
+
+	if err := ctx.Err(); err == context.DeadlineExceeded {
+		return fmt.Errorf("%w: %w", ErrTimeout, err)
+	}
+
+	return ctx.Err()
+}
+
+// HandlerPanic, panic recovered in action callback or event handler
+type HandlerPanic struct {
+	Event    string      // Event header of handled message
+	ActionID string      // ActionID header of handled message
+	Value    interface{} // value passed to panic
+	Stack    []byte      // goroutine stack trace
+}
+
+// Error, error interface implementation
+func (e *HandlerPanic) Error() string {
+
+	return fmt.Sprintf("gami: handler panic (Event: %q, ActionID: %q): %v\n%s", e.Event, e.ActionID, e.Value, e.Stack)
+}
@@ -0,0 +1,449 @@
+package gami
+
+import (
+	"context"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Predicate, event matching rule
+type Predicate func(Message) bool
+
+// HeaderEquals, predicate: header has exact value (Uniqueid, Linkedid, Context ...)
+func HeaderEquals(key, value string) Predicate {
+
+	return func(m Message) bool {
+		v, ok := m[key]
+		return ok && v == value
+	}
+}
+
+// HeaderPrefix, predicate: header value starts with prefix (Channel "SIP/100-" ...)
+func HeaderPrefix(key, prefix string) Predicate {
+
+	return func(m Message) bool {
+		v, ok := m[key]
+		return ok && strings.HasPrefix(v, prefix)
+	}
+}
+
+// HeaderPattern, predicate: header value matches glob pattern ('*' any characters including '/',
+// "SIP/*" matches "SIP/trunk/100", '?' one character, "[a-z]" class, '\\' escape)
+func HeaderPattern(key, pattern string) Predicate {
+
+	return func(m Message) bool {
+		v, ok := m[key]
+		if !ok {
+			return false
+		}
+		r, _ := globMatch(pattern, v)
+		return r
+	}
+}
+
+// globMatch, shell pattern matching like path.Match, but '/' is not special (channel names
+// contain it), path.ErrBadPattern for malformed pattern
+func globMatch(pattern, s string) (bool, error) {
+
+	if err := validGlob(pattern); err != nil {
+		return false, err
+	}
+
+	px, sx := 0, 0
+	star, next := -1, 0 // last '*' position and input position to retry it from
+	for px < len(pattern) || sx < len(s) {
+		if px < len(pattern) {
+			switch c := pattern[px]; c {
+			case '*':
+				star, next = px, sx
+				px++
+				continue
+			case '?':
+				if sx < len(s) {
+					_, w := utf8.DecodeRuneInString(s[sx:])
+					px, sx = px+1, sx+w
+					continue
+				}
+			case '[':
+				if sx < len(s) {
+					r, w := utf8.DecodeRuneInString(s[sx:])
+					if ok, n, _ := matchClass(pattern[px:], r); ok {
+						px, sx = px+n, sx+w
+						continue
+					}
+				}
+			default:
+				if c == '\\' {
+					px++
+				}
+				if sx < len(s) && s[sx] == pattern[px] {
+					px, sx = px+1, sx+1
+					continue
+				}
+			}
+		}
+
+		// mismatch, let last '*' take one more character
+		if star < 0 || next >= len(s) {
+			return false, nil
+		}
+		_, w := utf8.DecodeRuneInString(s[next:])
+		next += w
+		px, sx = star+1, next
+	}
+
+	return true, nil
+}
+
+// validGlob, checks pattern syntax
+func validGlob(pattern string) error {
+
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			if i++; i == len(pattern) {
+				return path.ErrBadPattern
+			}
+		case '[':
+			_, n, err := matchClass(pattern[i:], 0)
+			if err != nil {
+				return err
+			}
+			i += n - 1
+		}
+	}
+
+	return nil
+}
+
+// matchClass, matches rune against "[...]" class at start of p ('^' negates, "a-z" ranges),
+// n is class length in pattern
+func matchClass(p string, r rune) (matched bool, n int, err error) {
+
+	i := 1
+	negated := i < len(p) && p[i] == '^'
+	if negated {
+		i++
+	}
+
+	// classChar, reads (escaped) class character
+	classChar := func() (rune, error) {
+		if i < len(p) && p[i] == '\\' {
+			i++
+		}
+		if i >= len(p) {
+			return 0, path.ErrBadPattern
+		}
+		c, w := utf8.DecodeRuneInString(p[i:])
+		i += w
+		return c, nil
+	}
+
+	for first := true; ; first = false {
+		if i >= len(p) {
+			return false, 0, path.ErrBadPattern
+		}
+		if p[i] == ']' && !first {
+			return matched != negated, i + 1, nil
+		}
+
+		lo, err := classChar()
+		if err != nil {
+			return false, 0, err
+		}
+		hi := lo
+		if i < len(p) && p[i] == '-' {
+			i++
+			if hi, err = classChar(); err != nil {
+				return false, 0, err
+			}
+		}
+		if lo <= r && r <= hi {
+			matched = true
+		}
+	}
+}
+
+// EventFilter, client side event matching rules
+type EventFilter struct {
+	Event string      // event name glob pattern (see HeaderPattern, "Queue*"), empty - any event
+	Match []Predicate // all predicates must match
+}
+
+// validate, checks event pattern syntax
+func (ef *EventFilter) validate() error {
+
+	_, err := globMatch(ef.Event, "")
+	return err
+}
+
+// match, true if event matches filter
+func (ef *EventFilter) match(m Message) bool {
+
+	if ef.Event != "" {
+		if ok, _ := globMatch(ef.Event, m["Event"]); !ok {
+			return false
+		}
+	}
+
+	for _, p := range ef.Match {
+		if !p(m) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Subscription, event handler registration (many subscriptions per event allowed)
+type Subscription struct {
+	event  string        // event name
+	filter *EventFilter  // matching rules (nil for exact event name)
+	f      func(Message) // handler
+	sl     *subList      // owner storage
+	direct bool          // run by read dispatcher in receive order (Events streams)
+}
+
+// Unsubscribe, removes this subscription only (safe to call more than once)
+func (s *Subscription) Unsubscribe() {
+
+	s.sl.del(s)
+}
+
+// Event, returns subscribed event name (or pattern)
+func (s *Subscription) Event() string {
+
+	if s.filter != nil {
+		return s.filter.Event
+	}
+
+	return s.event
+}
+
+// subList, event subscriptions storage
+type subList struct {
+	mu *sync.RWMutex
+	s  map[string][]*Subscription // subscriptions by event name
+	p  []*Subscription            // filter subscriptions
+}
+
+// newSubList, subList factory
+func newSubList() *subList {
+
+	return &subList{
+		mu: &sync.RWMutex{},
+		s:  make(map[string][]*Subscription),
+	}
+}
+
+// add, adds subscription
+func (sl *subList) add(s *Subscription) {
+
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	if s.filter != nil {
+		sl.p = append(sl.p, s)
+		return
+	}
+
+	sl.s[s.event] = append(sl.s[s.event], s)
+}
+
+// without, returns copy of l without s (slices returned by match must stay untouched)
+func without(l []*Subscription, s *Subscription) []*Subscription {
+
+	for i, v := range l {
+		if v == s {
+			nl := make([]*Subscription, 0, len(l)-1)
+			nl = append(nl, l[:i]...)
+			return append(nl, l[i+1:]...)
+		}
+	}
+
+	return l
+}
+
+// del, removes subscription
+func (sl *subList) del(s *Subscription) {
+
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	if s.filter != nil {
+		sl.p = without(sl.p, s)
+		return
+	}
+
+	sl.s[s.event] = without(sl.s[s.event], s)
+	if len(sl.s[s.event]) == 0 {
+		delete(sl.s, s.event)
+	}
+}
+
+// match, returns subscriptions matching event message
+func (sl *subList) match(m Message) []*Subscription {
+
+	sl.mu.RLock()
+	defer sl.mu.RUnlock()
+
+	l := sl.s[m["Event"]]
+	if len(sl.p) == 0 {
+		return l
+	}
+
+	ml := append([]*Subscription(nil), l...)
+	for _, s := range sl.p {
+		if s.filter.match(m) {
+			ml = append(ml, s)
+		}
+	}
+
+	return ml
+}
+
+// Subscribe, register handler for Asterisk event, any number of handlers per event allowed
+// returned Subscription removes only this handler (pointer wrapper of On)
+func (a *Asterisk) Subscribe(event string, f *func(Message)) *Subscription {
+
+	return a.On(event, deref(f))
+}
+
+// SubscribeFilter, register handler for events matching filter (event name pattern and predicates)
+// returns error for malformed event pattern (pointer wrapper of OnFilter)
+func (a *Asterisk) SubscribeFilter(ef *EventFilter, f *func(Message)) (*Subscription, error) {
+
+	return a.OnFilter(ef, deref(f))
+}
+
+// subscribeFilter, registers filter subscription, direct one runs by read dispatcher
+func (a *Asterisk) subscribeFilter(ef *EventFilter, f func(Message), direct bool) (*Subscription, error) {
+
+	if err := ef.validate(); err != nil {
+		return nil, err
+	}
+
+	s := &Subscription{
+		filter: ef,
+		f:      f,
+		sl:     a.subscriptions,
+		direct: direct,
+	}
+	a.subscriptions.add(s)
+
+	return s, nil
+}
+
+// stream, channel based events subscriber
+type stream struct {
+	mu      *sync.Mutex
+	ch      chan Message
+	stop    chan struct{} // closed first, unblocks waiting push
+	once    *sync.Once
+	closed  bool
+	policy  QueuePolicy
+	dropped *uint64
+}
+
+// push, delivers message to stream according to policy
+func (s *stream) push(m Message) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	switch s.policy {
+	case QueueBlock:
+		select {
+		case s.ch <- m:
+		case <-s.stop:
+		}
+	case QueueDropOldest:
+		for {
+			select {
+			case s.ch <- m:
+				return
+			default:
+			}
+			select {
+			case <-s.ch:
+				atomic.AddUint64(s.dropped, 1)
+			default:
+			}
+		}
+	default:
+		select {
+		case s.ch <- m:
+		default:
+			atomic.AddUint64(s.dropped, 1)
+		}
+	}
+}
+
+// close, closes stream channel (safe to call more than once)
+func (s *stream) close() {
+
+	s.once.Do(func() {
+		close(s.stop)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.closed = true
+		close(s.ch)
+	})
+}
+
+// SetStreamBuffer, set buffer size and slow consumer policy for new Events streams
+// (default 256, QueueDropNewest), dropped events are counted by StreamDropped,
+// QueueBlock stalls reading from socket until consumer reads
+func (a *Asterisk) SetStreamBuffer(size int, policy QueuePolicy) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.streamSize = size
+	a.streamPolicy = policy
+}
+
+// Events, returns channel with events matching filter (nil - all events)
+// channel is closed when ctx is done or connection is lost
+func (a *Asterisk) Events(ctx context.Context, ef *EventFilter) (<-chan Message, error) {
+
+	if ef == nil {
+		ef = &EventFilter{}
+	}
+
+	a.mu.RLock()
+	st := &stream{
+		mu:      &sync.Mutex{},
+		ch:      make(chan Message, a.streamSize),
+		stop:    make(chan struct{}),
+		once:    &sync.Once{},
+		policy:  a.streamPolicy,
+		dropped: a.streamDropped,
+	}
+	done := a.sessionDone
+	a.mu.RUnlock()
+
+	// pushed by read dispatcher, stream keeps receive order in any dispatch mode
+	f := st.push
+	sub, err := a.subscribeFilter(ef, f, true)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		sub.Unsubscribe()
+		st.close()
+	}()
+
+	return st.ch, nil
+}
@@ -0,0 +1,217 @@
+package gami
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+// expectMessages, reads n messages from channel, fails on timeout
+func expectMessages(c *check.C, ch <-chan Message, n int) []Message {
+	ml := []Message{}
+	for i := 0; i < n; i++ {
+		select {
+		case m := <-ch:
+			ml = append(ml, m)
+		case <-time.After(time.Second):
+			c.Fatalf("expected %d messages, received %d", n, len(ml))
+		}
+	}
+	return ml
+}
+
+// expectNothing, fails if message received on channel
+func expectNothing(c *check.C, ch <-chan Message) {
+	select {
+	case m := <-ch:
+		c.Fatalf("unexpected message %v", m)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (s *UnitSuite) TestSubscribe(c *check.C) {
+	a, srv := newPipeAsterisk(c, func(Message) []Message { return nil })
+	defer srv.Close()
+
+	ch1 := make(chan Message, 10)
+	ch2 := make(chan Message, 10)
+	f1 := func(m Message) { ch1 <- m }
+	f2 := func(m Message) { ch2 <- m }
+
+	s1 := a.Subscribe("Hangup", &f1)
+	s2 := a.Subscribe("Hangup", &f2)
+	c.Assert(s1.Event(), check.Equals, "Hangup")
+
+	srv.Write([]byte("Event: Hangup\r\nChannel: SIP/1\r\n\r\n"))
+	c.Assert(expectMessages(c, ch1, 1)[0]["Channel"], check.Equals, "SIP/1")
+	c.Assert(expectMessages(c, ch2, 1)[0]["Channel"], check.Equals, "SIP/1")
+
+	s1.Unsubscribe()
+	s1.Unsubscribe()
+
+	srv.Write([]byte("Event: Hangup\r\nChannel: SIP/2\r\n\r\n"))
+	c.Assert(expectMessages(c, ch2, 1)[0]["Channel"], check.Equals, "SIP/2")
+	expectNothing(c, ch1)
+
+	s2.Unsubscribe()
+	c.Assert(a.subscriptions.s, check.HasLen, 0)
+}
+
+func (s *UnitSuite) TestSubscribeFilter(c *check.C) {
+	a, srv := newPipeAsterisk(c, func(Message) []Message { return nil })
+	defer srv.Close()
+
+	chq := make(chan Message, 10)
+	chc := make(chan Message, 10)
+	fq := func(m Message) { chq <- m }
+	fc := func(m Message) { chc <- m }
+
+	sq, err := a.SubscribeFilter(&EventFilter{Event: "Queue*"}, &fq)
+	c.Assert(err, check.IsNil)
+	c.Assert(sq.Event(), check.Equals, "Queue*")
+	_, err = a.SubscribeFilter(&EventFilter{
+		Match: []Predicate{HeaderPrefix("Channel", "SIP/100-"), HeaderEquals("Context", "default")},
+	}, &fc)
+	c.Assert(err, check.IsNil)
+
+	srv.Write([]byte("Event: QueueMemberStatus\r\nQueue: q1\r\n\r\n"))
+	srv.Write([]byte("Event: Newstate\r\nChannel: SIP/100-0001\r\nContext: default\r\n\r\n"))
+	srv.Write([]byte("Event: Newstate\r\nChannel: SIP/200-0002\r\nContext: default\r\n\r\n"))
+	srv.Write([]byte("Event: Hangup\r\nChannel: SIP/100-0001\r\nContext: other\r\n\r\n"))
+
+	c.Assert(expectMessages(c, chq, 1)[0]["Queue"], check.Equals, "q1")
+	c.Assert(expectMessages(c, chc, 1)[0]["Event"], check.Equals, "Newstate")
+	expectNothing(c, chq)
+	expectNothing(c, chc)
+
+	sq.Unsubscribe()
+	srv.Write([]byte("Event: QueueParams\r\nQueue: q1\r\n\r\n"))
+	expectNothing(c, chq)
+
+	_, err = a.SubscribeFilter(&EventFilter{Event: "Queue["}, &fq)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *UnitSuite) TestPredicates(c *check.C) {
+	m := Message{"Channel": "PJSIP/100-00000001", "Uniqueid": "1600000000.1"}
+	c.Assert(HeaderEquals("Uniqueid", "1600000000.1")(m), check.Equals, true)
+	c.Assert(HeaderEquals("Linkedid", "")(m), check.Equals, false)
+	c.Assert(HeaderPrefix("Channel", "PJSIP/100-")(m), check.Equals, true)
+	c.Assert(HeaderPattern("Channel", "PJSIP/*")(m), check.Equals, true)
+	c.Assert(HeaderPattern("Channel", "SIP/*")(m), check.Equals, false)
+
+	// '/' is not special in channel names
+	c.Assert(HeaderPattern("Channel", "Local/*")(Message{"Channel": "Local/100@ctx-0001;1"}), check.Equals, true)
+	c.Assert(HeaderPattern("Channel", "PJSIP/*")(Message{"Channel": "PJSIP/trunk/100-00000002"}), check.Equals, true)
+	c.Assert(HeaderPattern("Channel", "*/100-*")(Message{"Channel": "PJSIP/trunk/100-00000002"}), check.Equals, true)
+}
+
+func (s *UnitSuite) TestGlobMatch(c *check.C) {
+	for _, t := range []struct {
+		pattern, s string
+		ok         bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "SIP/a/b", true},
+		{"Queue*", "QueueMemberStatus", true},
+		{"Queue*", "Hangup", false},
+		{"SIP/*-*", "SIP/trunk/100-00000001", true},
+		{"SIP/???", "SIP/100", true},
+		{"SIP/???", "SIP/10", false},
+		{"?", "ж", true},
+		{"*a*b", "xaxxb", true},
+		{"*a*b", "xaxxbc", false},
+		{"[a-c]x", "bx", true},
+		{"[^a-c]x", "bx", false},
+		{"[]]", "]", true},
+		{"\\*", "*", true},
+		{"\\*", "a", false},
+	} {
+		ok, err := globMatch(t.pattern, t.s)
+		c.Assert(err, check.IsNil, check.Commentf(t.pattern))
+		c.Assert(ok, check.Equals, t.ok, check.Commentf("%q %q", t.pattern, t.s))
+	}
+
+	for _, bad := range []string{"[", "[a", "[a-", "\\", "a[]"} {
+		_, err := globMatch(bad, "a")
+		c.Assert(err, check.Equals, path.ErrBadPattern, check.Commentf(bad))
+	}
+}
+
+func (s *UnitSuite) TestEventsStream(c *check.C) {
+	a, srv := newPipeAsterisk(c, func(Message) []Message { return nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := a.Events(ctx, &EventFilter{Event: "Hangup"})
+	c.Assert(err, check.IsNil)
+	all, err := a.Events(context.Background(), nil)
+	c.Assert(err, check.IsNil)
+
+	srv.Write([]byte("Event: Newstate\r\nChannel: SIP/1\r\n\r\n"))
+	srv.Write([]byte("Event: Hangup\r\nChannel: SIP/1\r\n\r\n"))
+
+	c.Assert((<-ch)["Event"], check.Equals, "Hangup")
+	c.Assert(len(a.subscriptions.p), check.Equals, 2)
+	cancel()
+	_, ok := <-ch
+	c.Assert(ok, check.Equals, false)
+
+	// connection lost closes stream
+	srv.Close()
+	n := 0
+	for range all {
+		n++
+	}
+	c.Assert(n, check.Equals, 2)
+	c.Assert(a.subscriptions.p, check.HasLen, 0)
+}
+
+func (s *UnitSuite) TestEventsOrder(c *check.C) {
+	a, srv := newPipeAsterisk(c, func(Message) []Message { return nil })
+	defer srv.Close()
+
+	a.SetStreamBuffer(200, QueueBlock)
+	ch, err := a.Events(context.Background(), &EventFilter{Event: "UserEvent"})
+	c.Assert(err, check.IsNil)
+
+	go func() {
+		for i := 0; i < 200; i++ {
+			fmt.Fprintf(srv, "Event: UserEvent\r\nSeq: %d\r\n\r\n", i)
+		}
+	}()
+
+	// default concurrent mode, stream keeps receive order
+	for i, m := range expectMessages(c, ch, 200) {
+		c.Assert(m["Seq"], check.Equals, fmt.Sprint(i))
+	}
+}
+
+func (s *UnitSuite) TestEventsSlowConsumer(c *check.C) {
+	a, srv := newPipeAsterisk(c, func(Message) []Message { return nil })
+	defer srv.Close()
+
+	a.SetDispatchMode(DispatchOrdered, nil)
+	a.SetStreamBuffer(2, QueueDropNewest)
+	ch, err := a.Events(context.Background(), nil)
+	c.Assert(err, check.IsNil)
+
+	// runs after stream handler (same ordered executor)
+	seen := make(chan Message, 10)
+	f := func(m Message) { seen <- m }
+	a.SubscribeFilter(&EventFilter{Event: "Newstate"}, &f)
+
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(srv, "Event: Newstate\r\nSeq: %d\r\n\r\n", i)
+	}
+	expectMessages(c, seen, 5)
+
+	c.Assert((<-ch)["Seq"], check.Equals, "0")
+	c.Assert((<-ch)["Seq"], check.Equals, "1")
+	c.Assert(a.StreamDropped(), check.Equals, uint64(3))
+	c.Assert(a.PoolDropped(), check.Equals, uint64(0))
+}
@@ -1,29 +1,30 @@
 package gami
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"fmt"
 	"net"
 	"os"
+	"strings"
 	"sync"
+	"time"
+	"unsafe"
 )
 
 const (
 	_LINE_TERM    = "\r\n"            // packet line separator
 	_KEY_VAL_TERM = ": "              // header value separator
-	_READ_BUF     = 512               // buffer size for socket reader
+	_READ_BUF     = 4096              // buffer size for socket reader
 	_CMD_END      = "--END COMMAND--" // Asterisk command data end
 	_HOST         = "gami"            // default host value
+	_STREAM_BUF   = 256               // default Events stream buffer size
+	_BANNER_TMOUT = 5 * time.Second   // banner wait limit when AMI version is required
 	ORIG_TMOUT    = 30000             // Originate timeout
 	VER           = 0.2
 )
 
-var (
-	_PT_BYTES = []byte(_LINE_TERM + _LINE_TERM) // packet separator
-)
-
-// basic Asterisk message
+// basic Asterisk message (map view of Headers, repeated header values are "\n" separated,
+// so "\n" in value is always sent as repeated header)
 type Message map[string]string
 
 // action id generator
@@ -81,6 +82,17 @@ func (cbl *cbList) del(key string) {
 	delete(cbl.sd, key)
 }
 
+// drain, removes all callbacks and returns them
+func (cbl *cbList) drain() map[string]*func(Message) {
+
+	cbl.mu.Lock()
+	defer cbl.mu.Unlock()
+	f := cbl.f
+	cbl.f = make(map[string]*func(Message))
+	cbl.sd = make(map[string]bool)
+	return f
+}
+
 // get, returns function for specific action id/event
 func (cbl *cbList) get(key string) (*func(Message), bool) {
 
@@ -131,138 +143,175 @@ func NewOriginateApp(channel, app, data string) *Originate {
 
 // main working entity
 type Asterisk struct {
-	conn           *net.Conn      // network connection to Asterisk
-	actionHandlers *cbList        // action response handle functions
-	eventHandlers  *cbList        // event handle functions
-	defaultHandler *func(Message) // default handler for all Asterisk messages, useful for debugging
-	netErrHandler  *func(error)   // network error handle function
-	aid            *Aid           // action id
-	authorized     bool           // is successful logined to AMI
+	conn           *net.Conn                // network connection to Asterisk
+	connMu         *sync.RWMutex            // guards conn and abandoned (replaced on reconnect)
+	abandoned      net.Conn                 // connection of failed reconnect attempt, its loss is not reported
+	actionHandlers *cbList                  // action response handle functions
+	subscriptions  *subList                 // event subscriptions (many per event)
+	registered     map[string]*Subscription // RegisterHandler subscriptions (one per event)
+	defaultHandler func(Message)            // default handler for all Asterisk messages, useful for debugging
+	rawHandler     func(Headers)            // handler for all packets in wire order
+	netErrHandler  *func(error)             // network error handle function
+	errHandler     func(error)              // handler panic report function
+	stateHandler   func(ConnState, error)   // connection state handle function
+	aid            *Aid                     // action id
+	authorized     *int32                   // is successful logined to AMI (1), atomic
+	authType       AuthType                 // Login authentication type
+	mu             *sync.RWMutex            // guards session options and executors
+	actionExec     executor                 // action callbacks executor
+	eventExec      executor                 // event and default handlers executor
+	eventKey       KeyFunc                  // event serialization key (DispatchKeyed mode)
+	dispatchMode   DispatchMode             // handlers execution mode
+	poolWorkers    int                      // worker pool size, 0 - goroutine per handler
+	poolQueue      int                      // worker pool queue length
+	poolPolicy     QueuePolicy              // worker pool full queue policy
+	poolDropped    *uint64                  // messages dropped by worker pool
+	streamDropped  *uint64                  // events dropped by Events streams
+	streamSize     int                      // Events stream buffer size
+	streamPolicy   QueuePolicy              // Events stream full buffer policy
+	sessionDone    chan struct{}            // closed when current connection is lost
+	readerDone     chan struct{}            // closed when current read dispatcher exits
+	closed         chan struct{}            // closed by Close
+	closeOnce      *sync.Once               // Close runs once
+	dropErr        error                    // reason of connection closed by client (keepalive)
+	kaInterval     time.Duration            // keepalive Ping interval, 0 - disabled
+	kaTimeout      time.Duration            // keepalive Pong wait limit
+	rtt            *int64                   // last Ping round-trip time (ns), atomic
+	maxPacket      int                      // received packet size limit
+	wr             *writer                  // outgoing packets writer
+	version        Version                  // AMI version from banner
+	minVersion     Version                  // required AMI version
+	eventMask      string                   // Events header for Login
+	filters        []string                 // server side event filters (installed on each Login)
+	rc             *reconnector             // dialer and credentials for reconnecting client (nil if not owns dialer)
 }
 
 // NewAsterisk, Asterisk factory
 func NewAsterisk(conn *net.Conn, f *func(error)) *Asterisk {
 
-	return &Asterisk{
-		conn: conn,
+	a := &Asterisk{
+		conn:   conn,
+		connMu: &sync.RWMutex{},
+		mu:     &sync.RWMutex{},
 		actionHandlers: &cbList{
 			&sync.RWMutex{},
 			make(map[string]*func(Message)),
 			make(map[string]bool),
 		},
-		eventHandlers: &cbList{
-			&sync.RWMutex{},
-			make(map[string]*func(Message)),
-			make(map[string]bool),
-		},
+		subscriptions: newSubList(),
+		registered:    make(map[string]*Subscription),
 		aid:           NewAid(),
+		actionExec:    newKeyedExecutor(),
+		eventExec:     newGoExecutor(),
+		authorized:    new(int32),
+		poolDropped:   new(uint64),
+		streamDropped: new(uint64),
+		rtt:           new(int64),
+		streamSize:    _STREAM_BUF,
+		streamPolicy:  QueueDropNewest,
+		sessionDone:   make(chan struct{}),
+		closed:        make(chan struct{}),
+		closeOnce:     &sync.Once{},
 		netErrHandler: f,
 	}
+	a.wr = newWriter(a.connection)
+
+	return a
 }
 
-// send, send Message to socket
-func (a *Asterisk) send(m Message) error {
+// send, send Message to socket, ctx limits waiting for writer
+func (a *Asterisk) send(ctx context.Context, m Message) error {
 
-	buf := bytes.NewBufferString("")
+	return a.write(ctx, m.Headers().marshal())
+}
 
-	for k, v := range m {
-		buf.Write([]byte(k))
-		buf.Write([]byte(_KEY_VAL_TERM))
-		buf.Write([]byte(v))
-		buf.Write([]byte(_LINE_TERM))
-	}
-	buf.Write([]byte(_LINE_TERM))
+// write, write packet to socket (serialized with other goroutines)
+func (a *Asterisk) write(ctx context.Context, p []byte) error {
 
-	if wrb, err := (*a.conn).Write(buf.Bytes()); wrb != buf.Len() || err != nil {
-		if err != nil {
-			return err
-		}
-		return fmt.Errorf("Not fully writed packet to output stream\n")
+	return a.wr.write(ctx, p)
+}
+
+// closedText, Message of closedMessage, own copy: received values never share its memory
+var closedText = strings.Clone(ErrConnectionClosed.Error())
+
+// closedMessage, error response for pointer callbacks of actions pending on closed connection
+// (only usual headers, see ResponseError)
+func closedMessage(aid string) Message {
+
+	return Message{
+		"Response": "Error",
+		"Message":  closedText,
+		"ActionID": aid,
 	}
+}
 
-	return nil
+// isClosedMessage, reports if m is closedMessage (not received from Asterisk), Asterisk
+// response with same text is told apart by memory of value
+func isClosedMessage(m Message) bool {
+
+	v := m["Message"]
+	return v == closedText && unsafe.StringData(v) == unsafe.StringData(closedText)
 }
 
-// readDispatcher, reads data from socket and builds messages
-func (a *Asterisk) readDispatcher() {
+// readDispatcher, reads packets from conn and runs handlers, closes ready when first line
+// is read and done on exit
+func (a *Asterisk) readDispatcher(conn net.Conn, ready, done chan struct{}) {
 
-	r := bufio.NewReader(*a.conn)
-	pbuf := bytes.NewBufferString("") // data buffer
-	buf := make([]byte, _READ_BUF)    // read buffer
+	defer close(done)
 
-	for {
-		rc, err := r.Read(buf)
+	a.mu.RLock()
+	p := newParser(conn, a.maxPacket)
+	a.mu.RUnlock()
 
-		if err != nil { // network error
-			a.authorized = false // unauth
+	// greeting is parsed before first packet, Login waits for it
+	once := &sync.Once{}
+	signal := func() {
+		once.Do(func() { close(ready) })
+	}
+	defer signal()
 
-			if a.netErrHandler != nil { // run network error callback
-				(*a.netErrHandler)(err)
-			}
-			return
+	p.onFirst = func(banner string) {
+		if banner != "" {
+			a.setBanner(banner)
 		}
+		signal()
+	}
 
-		wb, err := pbuf.Write(buf[:rc])
+	for {
+		h, err := p.next()
 
-		if err != nil || wb != rc { // can't write to data buffer, just skip
+		if err == ErrPacketTooLarge { // packet skipped, stream is fine
+			a.reportError(err)
 			continue
 		}
 
-		// while has end of packet symbols in buffer
-		for pos := bytes.Index(pbuf.Bytes(), _PT_BYTES); pos != -1; pos = bytes.Index(pbuf.Bytes(), _PT_BYTES) {
-
-			bp := make([]byte, pos+len(_PT_BYTES))
-			r, err := pbuf.Read(bp)                    // reading packet to separate puffer
-			if err != nil || r != pos+len(_PT_BYTES) { // reading problems, just skip
-				continue
+		if err != nil { // network error
+			a.mu.Lock()
+			if a.dropErr != nil { // connection dropped by client, report reason
+				err, a.dropErr = a.dropErr, nil
 			}
+			a.mu.Unlock()
 
-			m := make(Message)
-
-			// splitting packet by line separator
-			for _, line := range bytes.Split(bp, []byte(_LINE_TERM)) {
-
-				// empty line
-				if len(line) == 0 {
-					continue
-				}
-
-				kvl := bytes.Split(line, []byte(_KEY_VAL_TERM))
+			a.connLost(conn, err)
+			return
+		}
 
-				// not standard header
-				if len(kvl) == 1 {
-					if string(line) != _CMD_END {
-						m["CmdData"] += string(line)
-					}
-					continue
-				}
+		a.dispatch(h)
+	}
+}
 
-				k := bytes.TrimSpace(kvl[0])
-				v := bytes.TrimSpace(kvl[1])
-				m[string(k)] = string(v)
-			}
+// SetWriteTimeout, set deadline for each socket write (failed write closes connection
+// and returns error to caller), 0 - no deadline, default 10s
+func (a *Asterisk) SetWriteTimeout(d time.Duration) {
 
-			// if has ActionID and has callback run it and delete
-			if v, vok := m["ActionID"]; vok {
-				if f, sd := a.actionHandlers.get(v); f != nil {
-					go (*f)(m)
-					if !sd { // will never remove "self-delete" callbacks
-						a.actionHandlers.del(v)
-					}
-				}
-			}
+	a.wr.setTimeout(d)
+}
 
-			// if Event and has callback run it
-			if v, vok := m["Event"]; vok {
-				if f, _ := a.eventHandlers.get(v); f != nil {
-					go (*f)(m)
-				}
-			}
+// SetMaxPacketSize, set limit of received packet size (bigger packets are skipped
+// and reported to error hook as ErrPacketTooLarge), 0 - default 1MiB
+func (a *Asterisk) SetMaxPacketSize(n int) {
 
-			// run default handler if not nil
-			if a.defaultHandler != nil {
-				go (*a.defaultHandler)(m)
-			}
-		}
-	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.maxPacket = n
 }
@@ -2,6 +2,8 @@ package gami
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	check "gopkg.in/check.v1"
@@ -9,6 +11,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -64,6 +67,747 @@ func (s *UnitSuite) TestCb(t *check.C) {
 	}
 }
 
+func (s *UnitSuite) TestSendActionContext(c *check.C) {
+	a, srv := newPipeAsterisk(c, func(m Message) []Message {
+		switch m["Action"] {
+		case "Ping":
+			return []Message{{"Response": "Success", "Ping": "Pong"}}
+		case "Hangup":
+			return []Message{{"Response": "Error", "Message": "No such channel"}}
+		}
+		return nil // no response
+	})
+	defer srv.Close()
+
+	r, err := a.SendActionContext(context.Background(), Message{"Action": "Ping"})
+	c.Assert(err, check.IsNil)
+	c.Assert(r["Ping"], check.Equals, "Pong")
+
+	r, err = a.HangupContext(context.Background(), "SIP/1")
+	var ae *AMIError
+	c.Assert(errors.As(err, &ae), check.Equals, true)
+	c.Assert(ae.Message, check.Equals, "No such channel")
+	c.Assert(ae.ActionID, check.Equals, r["ActionID"])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	m := Message{"Action": "Silence"}
+	_, err = a.SendActionContext(ctx, m)
+	c.Assert(errors.Is(err, ErrTimeout), check.Equals, true)
+	c.Assert(errors.Is(err, context.DeadlineExceeded), check.Equals, true)
+	f, _ := a.actionHandlers.get(m["ActionID"])
+	c.Assert(f, check.IsNil)
+}
+
+func (s *UnitSuite) TestErrors(c *check.C) {
+	cln, srv := net.Pipe()
+	defer srv.Close()
+	go servePipe(srv, func(m Message) []Message {
+		if m["Action"] == "Login" {
+			return []Message{{"Response": "Error", "Message": "Authentication failed"}}
+		}
+		return nil
+	})
+
+	a := NewAsterisk(&cln, nil)
+	c.Assert(a.SendAction(Message{"Action": "Ping"}, nil), check.Equals, ErrNotAuthorized)
+
+	err := a.Login("admin", "wrong")
+	var ae *AMIError
+	c.Assert(errors.As(err, &ae), check.Equals, true)
+	c.Assert(ae.Message, check.Equals, "Authentication failed")
+
+	// server error with same text is not lost connection
+	err = ResponseError(Message{"Response": "Error", "Message": ErrConnectionClosed.Error()})
+	c.Assert(errors.Is(err, ErrConnectionClosed), check.Equals, false)
+	c.Assert(ResponseError(closedMessage("1")), check.Equals, ErrConnectionClosed)
+	c.Assert(ResponseError(Message{"Response": "Success"}), check.IsNil)
+
+	// closed message has usual headers only, copy is still recognized
+	var r struct {
+		Rest map[string]string `ami:",rest"`
+	}
+	c.Assert(Unmarshal(closedMessage("1"), &r), check.IsNil)
+	c.Assert(r.Rest, check.DeepEquals, map[string]string{
+		"Response": "Error", "Message": ErrConnectionClosed.Error(), "ActionID": "1",
+	})
+	cp := Message{}
+	for k, v := range closedMessage("1") {
+		cp[k] = v
+	}
+	c.Assert(ResponseError(cp), check.Equals, ErrConnectionClosed)
+
+	a.setAuthorized(true)
+	c.Assert(a.HoldCallbackAction(Message{"Action": "Status"}, nil), check.Equals, ErrNilCallback)
+
+	f := func(Message) {}
+	c.Assert(a.RegisterHandler("Hangup", &f), check.IsNil)
+	c.Assert(errors.Is(a.RegisterHandler("Hangup", &f), ErrHandlerExists), check.Equals, true)
+}
+
+func (s *UnitSuite) TestConnectionClosed(c *check.C) {
+	a, srv := newPipeAsterisk(c, func(m Message) []Message {
+		return nil // never answers
+	})
+
+	errc := make(chan error)
+	go func() {
+		_, err := a.SendActionContext(context.Background(), Message{"Action": "Ping"})
+		errc <- err
+	}()
+
+	lc := make(chan error)
+	go func() {
+		_, err := a.GetConfbridgeList("conf1")
+		lc <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond) // let actions reach server
+	srv.Close()
+
+	err := <-errc
+	c.Assert(err, check.Equals, ErrConnectionClosed)
+	var ae *AMIError
+	c.Assert(errors.As(err, &ae), check.Equals, false) // client failure, not Asterisk response
+	select {
+	case err = <-lc:
+		c.Assert(err, check.Equals, ErrConnectionClosed)
+	case <-time.After(time.Second):
+		c.Fatal("list action not completed")
+	}
+	c.Assert(a.actionHandlers.drain(), check.HasLen, 0)
+}
+
+func (s *UnitSuite) TestClose(c *check.C) {
+	actions := make(chan string, 10)
+	cln, srv := newPipe(c, func(m Message) []Message {
+		actions <- m["Action"]
+		switch m["Action"] {
+		case "Logoff":
+			return []Message{{"Response": "Goodbye", "Message": "Thanks for all the fish."}}
+		case "Wait":
+			return []Message{} // no response
+		}
+		return nil
+	})
+	defer srv.Close()
+
+	netErr := make(chan error, 1)
+	nf := func(err error) { netErr <- err }
+	a := NewAsterisk(&cln, &nf)
+	c.Assert(a.Login("admin", "admin"), check.IsNil)
+
+	// in-flight handler
+	started := make(chan bool)
+	finished := false
+	a.On("Slow", func(Message) {
+		started <- true
+		time.Sleep(100 * time.Millisecond)
+		finished = true
+	})
+	srv.Write([]byte("Event: Slow\r\n\r\n"))
+	<-started
+
+	// pending action
+	var pending Message
+	_, err := a.Send(Message{"Action": "Wait"}, func(m Message) { pending = m })
+	c.Assert(err, check.IsNil)
+
+	c.Assert(a.Close(context.Background()), check.IsNil)
+	c.Assert(finished, check.Equals, true)
+	c.Assert(ResponseError(pending), check.Equals, ErrConnectionClosed)
+	c.Assert(<-actions, check.Equals, "Login")
+	c.Assert(<-actions, check.Equals, "Wait")
+	c.Assert(<-actions, check.Equals, "Logoff")
+
+	// connection is closed, not a network error
+	_, err = cln.Read(make([]byte, 1))
+	c.Assert(err, check.NotNil)
+	select {
+	case err := <-netErr:
+		c.Fatalf("unexpected network error %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.Assert(a.Close(context.Background()), check.IsNil)
+	c.Assert(a.SendAction(Message{"Action": "Ping"}, nil), check.Equals, ErrNotAuthorized)
+}
+
+func (s *UnitSuite) TestCloseTimeout(c *check.C) {
+	a, srv := newPipeAsterisk(c, func(m Message) []Message {
+		if m["Action"] == "Logoff" {
+			return []Message{} // no Goodbye
+		}
+		return nil
+	})
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := a.Close(ctx)
+	c.Assert(errors.Is(err, ErrTimeout), check.Equals, true)
+
+	_, err = a.connection().Read(make([]byte, 1))
+	c.Assert(err, check.NotNil)
+}
+
+func (s *UnitSuite) TestCloseFromHandler(c *check.C) {
+	a, srv := newPipeAsterisk(c, func(m Message) []Message {
+		if m["Action"] == "Logoff" {
+			return []Message{{"Response": "Goodbye"}}
+		}
+		return nil
+	})
+	defer srv.Close()
+
+	// Close in own goroutine waits for handler which started it
+	done := make(chan error, 1)
+	finished := false
+	a.On("Shutdown", func(Message) {
+		go func() { done <- a.Close(context.Background()) }()
+		time.Sleep(50 * time.Millisecond)
+		finished = true
+	})
+	srv.Write([]byte("Event: Shutdown\r\n\r\n"))
+
+	select {
+	case err := <-done:
+		c.Assert(err, check.IsNil)
+		c.Assert(finished, check.Equals, true)
+	case <-time.After(2 * time.Second):
+		c.Fatal("Close from handler goroutine not finished")
+	}
+}
+
+func (s *UnitSuite) TestCloseDuringReconnect(c *check.C) {
+	dials := make(chan net.Conn, 2)
+	n := 0
+	dial := func() (net.Conn, error) {
+		n++
+		silent := n == 2 // reconnect Login is never answered
+		cln, srv := newPipe(c, func(m Message) []Message {
+			if silent {
+				return []Message{}
+			}
+			return nil
+		})
+		dials <- srv
+		return cln, nil
+	}
+
+	a, err := NewReconnectAsterisk(dial, &Reconnect{MinDelay: time.Millisecond, LoginTimeout: time.Minute}, nil)
+	c.Assert(err, check.IsNil)
+	st := make(chan ConnState, 2)
+	a.OnState(func(s ConnState, err error) { st <- s })
+	c.Assert(a.Login("admin", "admin"), check.IsNil)
+
+	(<-dials).Close()
+	c.Assert(<-st, check.Equals, Disconnected)
+	srv := <-dials // reconnect Login in flight
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c.Assert(a.Close(ctx), check.IsNil)
+
+	// new connection is closed, reconnect is not reported
+	_, err = a.connection().Read(make([]byte, 1))
+	c.Assert(err, check.NotNil)
+	c.Assert(a.isAuthorized(), check.Equals, false)
+	select {
+	case s := <-st:
+		c.Fatalf("unexpected state %v", s)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (s *UnitSuite) TestReconnect(c *check.C) {
+	srvc := make(chan net.Conn, 2)
+	logins := make(chan Message, 2)
+	dial := func() (net.Conn, error) {
+		cln, srv := net.Pipe()
+		go servePipe(srv, func(m Message) []Message {
+			if m["Action"] == "Login" {
+				logins <- m
+			}
+			return nil
+		})
+		srvc <- srv
+		return cln, nil
+	}
+
+	a, err := NewReconnectAsterisk(dial, &Reconnect{MinDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}, nil)
+	c.Assert(err, check.IsNil)
+
+	states := make(chan ConnState, 2)
+	sh := func(s ConnState, err error) {
+		states <- s
+	}
+	a.StateHandler(&sh)
+
+	evc := make(chan Message, 1)
+	eh := func(m Message) {
+		evc <- m
+	}
+	a.RegisterHandler("Hangup", &eh)
+
+	c.Assert(a.Login("admin", "secret"), check.IsNil)
+	<-logins
+	(<-srvc).Close()
+
+	c.Assert(<-states, check.Equals, Disconnected)
+	c.Assert(<-states, check.Equals, Reconnected)
+	m := <-logins
+	c.Assert(m["Username"], check.Equals, "admin")
+	c.Assert(m["Secret"], check.Equals, "secret")
+
+	srv := <-srvc
+	srv.Write([]byte("Event: Hangup\r\nChannel: SIP/1\r\n\r\n"))
+	c.Assert((<-evc)["Channel"], check.Equals, "SIP/1")
+
+	c.Assert(a.Logoff(), check.IsNil)
+	srv.Close()
+	c.Assert(<-states, check.Equals, Disconnected)
+	select {
+	case s := <-states:
+		c.Fatalf("unexpected state %s after logoff", s)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (s *UnitSuite) TestReconnectFailedAttempts(c *check.C) {
+	for i := 0; i < 10; i++ {
+		srvc := make(chan net.Conn, 4)
+		n := int32(0)
+		dial := func() (net.Conn, error) {
+			reject := atomic.AddInt32(&n, 1) <= 3 // first two re-logins fail
+			cln, srv := newPipe(c, func(m Message) []Message {
+				if reject && m["Action"] == "Login" && atomic.LoadInt32(&n) > 1 {
+					return []Message{{"Response": "Error", "Message": "Authentication failed"}}
+				}
+				return nil
+			})
+			srvc <- srv
+			return cln, nil
+		}
+
+		netErr := make(chan error, 4)
+		nf := func(err error) { netErr <- err }
+		a, err := NewReconnectAsterisk(dial, &Reconnect{MinDelay: time.Nanosecond}, &nf)
+		c.Assert(err, check.IsNil)
+		st := make(chan ConnState, 2)
+		a.OnState(func(s ConnState, err error) { st <- s })
+		c.Assert(a.Login("admin", "admin"), check.IsNil)
+
+		(<-srvc).Close()
+		c.Assert(<-st, check.Equals, Disconnected)
+		c.Assert(<-st, check.Equals, Reconnected)
+		c.Assert(atomic.LoadInt32(&n), check.Equals, int32(4))
+		c.Assert(a.isAuthorized(), check.Equals, true)
+
+		// only loss of established session is reported
+		c.Assert(netErr, check.HasLen, 1)
+
+		a.Logoff()
+		for len(srvc) > 0 {
+			(<-srvc).Close()
+		}
+	}
+}
+
+func (s *UnitSuite) TestReconnectDelay(c *check.C) {
+	r := &Reconnect{MinDelay: time.Second, MaxDelay: 5 * time.Second}
+	c.Assert(r.delay(0), check.Equals, time.Second)
+	c.Assert(r.delay(2), check.Equals, 4*time.Second)
+	c.Assert(r.delay(10), check.Equals, 5*time.Second)
+
+	// zero values are defaults, backoff is never disabled
+	r = &Reconnect{}
+	c.Assert(r.delay(0), check.Equals, 500*time.Millisecond)
+	c.Assert(r.delay(1), check.Equals, time.Second)
+	c.Assert(r.delay(20), check.Equals, 30*time.Second)
+	c.Assert(r.loginTimeout(), check.Equals, 10*time.Second)
+}
+
+func (s *UnitSuite) TestReconnectLoginTimeout(c *check.C) {
+	dials := make(chan net.Conn, 3)
+	n := 0
+	dial := func() (net.Conn, error) {
+		n++
+		silent := n == 2 // first reconnect gets server which never answers
+		cln, srv := newPipe(c, func(m Message) []Message {
+			if silent {
+				return []Message{}
+			}
+			return nil
+		})
+		dials <- srv
+		return cln, nil
+	}
+
+	a, err := NewReconnectAsterisk(dial, &Reconnect{MinDelay: time.Millisecond, LoginTimeout: 50 * time.Millisecond}, nil)
+	c.Assert(err, check.IsNil)
+	st := make(chan ConnState, 2)
+	a.OnState(func(s ConnState, err error) { st <- s })
+	c.Assert(a.Login("admin", "admin"), check.IsNil)
+
+	(<-dials).Close()
+	c.Assert(<-st, check.Equals, Disconnected)
+	select {
+	case s := <-st:
+		c.Assert(s, check.Equals, Reconnected)
+	case <-time.After(2 * time.Second):
+		c.Fatal("reconnect blocked by silent server")
+	}
+	c.Assert(n, check.Equals, 3)
+
+	a.Logoff()
+	for len(dials) > 0 {
+		(<-dials).Close()
+	}
+}
+
+func (s *UnitSuite) TestLoginMD5(c *check.C) {
+	cln, srv := net.Pipe()
+	defer srv.Close()
+	go servePipe(srv, func(m Message) []Message {
+		switch m["Action"] {
+		case "Challenge":
+			if m["AuthType"] != "MD5" {
+				break
+			}
+			return []Message{{"Response": "Success", "Challenge": "840415273"}}
+		case "Login":
+			_, plain := m["Secret"]
+			if !plain && m["AuthType"] == "MD5" && m["Key"] == md5Key("840415273", "secret") {
+				return []Message{{"Response": "Success", "Message": "Authentication accepted"}}
+			}
+		}
+		return []Message{{"Response": "Error", "Message": "Authentication failed"}}
+	})
+
+	a := NewAsterisk(&cln, nil)
+	a.SetAuthType(AuthMD5)
+	c.Assert(a.Login("admin", "secret"), check.IsNil)
+	c.Assert(md5Key("840415273", "secret"), check.Equals, "81c3ab5534a432bec402f6d988ee45b4")
+}
+
+func (s *UnitSuite) TestEventMaskFilters(c *check.C) {
+	srvc := make(chan net.Conn, 2)
+	reqs := make(chan Message, 10)
+	dial := func() (net.Conn, error) {
+		cln, srv := net.Pipe()
+		go servePipe(srv, func(m Message) []Message {
+			reqs <- m
+			if m["Action"] == "Login" {
+				return nil
+			}
+			return []Message{{"Response": "Success"}}
+		})
+		srvc <- srv
+		return cln, nil
+	}
+
+	a, err := NewReconnectAsterisk(dial, &Reconnect{MinDelay: time.Millisecond}, nil)
+	c.Assert(err, check.IsNil)
+	states := make(chan ConnState, 2)
+	sh := func(s ConnState, err error) {
+		states <- s
+	}
+	a.StateHandler(&sh)
+
+	a.SetEventMask("off")
+	c.Assert(a.Login("admin", "admin"), check.IsNil)
+	c.Assert((<-reqs)["Events"], check.Equals, "off")
+
+	c.Assert(a.EventMask(context.Background(), "call,system"), check.IsNil)
+	r := <-reqs
+	c.Assert(r["Action"], check.Equals, "Events")
+	c.Assert(r["EventMask"], check.Equals, "call,system")
+
+	c.Assert(a.AddFilter(context.Background(), "Event: Hangup"), check.IsNil)
+	r = <-reqs
+	c.Assert(r["Action"], check.Equals, "Filter")
+	c.Assert(r["Filter"], check.Equals, "Event: Hangup")
+	c.Assert(a.Filters(), check.DeepEquals, []string{"Event: Hangup"})
+
+	(<-srvc).Close()
+	c.Assert(<-states, check.Equals, Disconnected)
+	c.Assert(<-states, check.Equals, Reconnected)
+
+	r = <-reqs
+	c.Assert(r["Action"], check.Equals, "Login")
+	c.Assert(r["Events"], check.Equals, "call,system")
+	r = <-reqs
+	c.Assert(r["Action"], check.Equals, "Filter")
+	c.Assert(r["Operation"], check.Equals, "Add")
+	c.Assert(r["Filter"], check.Equals, "Event: Hangup")
+
+	a.Logoff()
+	(<-srvc).Close()
+}
+
+func (s *UnitSuite) TestLoginFilterFailure(c *check.C) {
+	reqs := make(chan string, 10)
+	cln, srv := newPipe(c, func(m Message) []Message {
+		reqs <- m["Action"]
+		switch m["Action"] {
+		case "Filter":
+			return []Message{{"Response": "Error", "Message": "Filter Not Permitted"}}
+		case "Logoff":
+			return []Message{{"Response": "Goodbye"}}
+		}
+		return nil
+	})
+	defer srv.Close()
+
+	a := NewAsterisk(&cln, nil)
+	a.filters = []string{"Event: Hangup"} // kept from previous session
+
+	var ae *AMIError
+	c.Assert(errors.As(a.Login("admin", "admin"), &ae), check.Equals, true)
+	c.Assert(ae.Message, check.Equals, "Filter Not Permitted")
+	c.Assert(a.isAuthorized(), check.Equals, false)
+
+	c.Assert(<-reqs, check.Equals, "Login")
+	c.Assert(<-reqs, check.Equals, "Filter")
+	c.Assert(<-reqs, check.Equals, "Logoff")
+}
+
+func (s *UnitSuite) TestVariables(c *check.C) {
+	vars := map[string]string{"b": "x,y", "a": `say "hi" \ bye`}
+
+	h := originateAction(NewOriginateApp("SIP/1", "Playback", "hello"), vars).Headers()
+	c.Assert(h.Values("Variable"), check.DeepEquals, []string{`a=say \"hi\" \\ bye`, `b=x\,y`})
+
+	h = messageSendAction("pjsip:100", "200", "text", false, vars).Headers()
+	c.Assert(h.Values("Variable"), check.HasLen, 2)
+
+	h = messageSendAction("pjsip:100", "200", "text", false, map[string]string{}).Headers()
+	c.Assert(h.Values("Variable"), check.HasLen, 0)
+
+	h = userEventAction("Test", map[string]string{"Key": "line1\r\nInjected: yes"}).Headers()
+	c.Assert(h.Values("Key"), check.DeepEquals, []string{"line1  Injected: yes"})
+	c.Assert(h.Get("Injected"), check.Equals, "")
+}
+
+func (s *UnitSuite) TestCommandOutput(c *check.C) {
+	cln, srv := net.Pipe()
+	defer srv.Close()
+
+	a := NewAsterisk(&cln, nil)
+	a.setAuthorized(true)
+	go a.readDispatcher(cln, make(chan struct{}), make(chan struct{}))
+
+	// Asterisk 1.6, Asterisk 16, empty output, failed command
+	replies := []string{
+		"Response: Follows\r\nPrivilege: Command\r\nActionID: %s\r\nName/username   Host\n\n100/100   (Unspecified)\n--END COMMAND--\r\n\r\n",
+		"Response: Success\r\nActionID: %s\r\nMessage: Command output follows\r\nOutput: Name/username   Host\r\nOutput: \r\nOutput: 100/100   (Unspecified)\r\n\r\n",
+		"Response: Success\r\nActionID: %s\r\nMessage: Command output follows\r\n\r\n",
+		"Response: Error\r\nActionID: %s\r\nMessage: Command output follows\r\nOutput: No such command 'bad'\r\n\r\n",
+	}
+	go func() {
+		r := bufio.NewReader(srv)
+		for _, reply := range replies {
+			aid := ""
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				l = strings.TrimRight(l, "\r\n")
+				if l == "" {
+					break
+				}
+				if strings.HasPrefix(l, "ActionID: ") {
+					aid = l[len("ActionID: "):]
+				}
+			}
+			fmt.Fprintf(srv, reply, aid)
+		}
+	}()
+
+	want := []string{"Name/username   Host", "", "100/100   (Unspecified)"}
+	for i := 0; i < 2; i++ {
+		out, err := a.CommandOutput(context.Background(), "sip show peers")
+		c.Assert(err, check.IsNil)
+		c.Assert(out, check.DeepEquals, want)
+	}
+
+	out, err := a.CommandOutput(context.Background(), "core show nothing")
+	c.Assert(err, check.IsNil)
+	c.Assert(out, check.HasLen, 0)
+
+	out, err = a.CommandOutput(context.Background(), "bad")
+	c.Assert(err, check.FitsTypeOf, &AMIError{})
+	c.Assert(out, check.DeepEquals, []string{"No such command 'bad'"})
+}
+
+func (s *UnitSuite) TestCollectList(c *check.C) {
+	a, srv := newPipeAsterisk(c, func(m Message) []Message {
+		switch m["Action"] {
+		case "CoreShowChannels":
+			rl := []Message{{"Response": "Success", "EventList": "start", "Message": "Channels will follow"}}
+			for i := 0; i < 50; i++ {
+				rl = append(rl, Message{"Event": "CoreShowChannel", "Channel": fmt.Sprintf("SIP/100-%08d", i)})
+			}
+			return append(rl, Message{"Event": "CoreShowChannelsComplete", "EventList": "Complete", "ListItems": "50"})
+		case "Status": // Asterisk 1.6, no EventList
+			return []Message{
+				{"Response": "Success", "Message": "Channel status will follow"},
+				{"Event": "Status", "Channel": "SIP/100-00000001"},
+				{"Event": "StatusComplete", "Items": "1"},
+			}
+		case "ConfbridgeList":
+			return []Message{{"Response": "Error", "Message": "No active conferences."}}
+		case "QueueStatus":
+			return []Message{{"Response": "Success", "EventList": "start"}}
+		}
+		return nil
+	})
+	defer srv.Close()
+
+	ml, err := a.CollectList(context.Background(), Message{"Action": "CoreShowChannels"})
+	c.Assert(err, check.IsNil)
+	c.Assert(ml, check.HasLen, 50)
+	for i, m := range ml { // order of list must be kept
+		c.Assert(m["Channel"], check.Equals, fmt.Sprintf("SIP/100-%08d", i))
+	}
+
+	ml, err = a.CollectList(context.Background(), Message{"Action": "Status"})
+	c.Assert(err, check.IsNil)
+	c.Assert(ml, check.HasLen, 1)
+	c.Assert(ml[0]["Event"], check.Equals, "Status")
+
+	ml, err = a.GetConfbridgeList("1000")
+	c.Assert(ml, check.IsNil)
+	var ae *AMIError
+	c.Assert(errors.As(err, &ae), check.Equals, true)
+	c.Assert(ae.Message, check.Equals, "No active conferences.")
+
+	// list never completes
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	m := Message{"Action": "QueueStatus"}
+	ml, err = a.CollectList(ctx, m)
+	c.Assert(ml, check.IsNil)
+	c.Assert(errors.Is(err, ErrTimeout), check.Equals, true)
+	f, _ := a.actionHandlers.get(m["ActionID"])
+	c.Assert(f, check.IsNil)
+}
+
+func (s *UnitSuite) TestCollectListDuplicateEnd(c *check.C) {
+	a, srv := newPipeAsterisk(c, func(m Message) []Message {
+		if m["Action"] == "CoreShowChannels" {
+			return []Message{} // list is completed by test
+		}
+		return nil
+	})
+	defer srv.Close()
+
+	res := make(chan error, 1)
+	go func() {
+		_, err := a.CollectList(context.Background(), Message{"Action": "CoreShowChannels"})
+		res <- err
+	}()
+
+	var fl map[string]*func(Message)
+	for len(fl) == 0 {
+		time.Sleep(time.Millisecond)
+		fl = a.actionHandlers.drain()
+	}
+
+	// terminal messages dispatched before callback was removed
+	called := make(chan bool)
+	go func() {
+		for aid, f := range fl {
+			(*f)(Message{"ActionID": aid, "Event": "CoreShowChannelsComplete", "EventList": "Complete"})
+			(*f)(Message{"ActionID": aid, "Event": "CoreShowChannelsComplete", "EventList": "Complete"})
+			(*f)(closedMessage(aid))
+		}
+		close(called)
+	}()
+
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		c.Fatal("callback blocked by duplicate list end")
+	}
+	c.Assert(<-res, check.IsNil)
+}
+
+func (s *UnitSuite) TestConcurrentUse(c *check.C) {
+	a, srv := newPipeAsterisk(c, func(m Message) []Message {
+		if m["Action"] == "UserEvent" {
+			return []Message{
+				{"Response": "Success"},
+				{"Event": "UserEvent", "UserEvent": m["UserEvent"], "ActionID": ""},
+			}
+		}
+		return []Message{{"Response": "Success"}}
+	})
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			f := func(Message) {}
+			sf := func(ConnState, error) {}
+			name := fmt.Sprintf("Test%d", i)
+
+			for j := 0; j < 50; j++ {
+				_, err := a.SendActionContext(ctx, Message{"Action": "Ping"})
+				c.Check(err, check.IsNil)
+				c.Check(a.Hangup("SIP/100-00000001", nil), check.IsNil)
+				c.Check(a.Redirect("SIP/100-00000001", "default", "200", "1", &f), check.IsNil)
+
+				a.DefaultHandler(&f)
+				a.StateHandler(&sf)
+				a.RawHandler(nil)
+				c.Check(a.RegisterHandler(name, &f), check.IsNil)
+
+				sub := a.Subscribe("UserEvent", &f)
+				_, err = a.UserEventContext(ctx, name, nil)
+				c.Check(err, check.IsNil)
+				sub.Unsubscribe()
+
+				a.UnregisterHandler(name)
+				a.Version()
+			}
+		}(i)
+	}
+	wg.Wait()
+	a.DefaultHandler(nil)
+
+	// connection lost while actions are sent, nobody hangs
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		go func() {
+			for {
+				if _, err := a.SendActionContext(ctx, Message{"Action": "Ping"}); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	srv.Close()
+
+	for i := 0; i < 8; i++ {
+		err := <-errs
+		c.Assert(errors.Is(err, ErrTimeout), check.Equals, false, check.Commentf("%v", err))
+	}
+	c.Assert(a.isAuthorized(), check.Equals, false)
+}
+
 func Test(t *testing.T) {
 	check.TestingT(t)
 }
@@ -218,6 +962,66 @@ func (s *IntegrationSuite) TearDownSuite(c *check.C) {
 	}
 }
 
+// newPipeAsterisk, logined Asterisk connected to in-memory mock
+// h returns responses for action (ActionID is filled automatically)
+func newPipeAsterisk(c *check.C, h func(Message) []Message) (*Asterisk, net.Conn) {
+	cln, srv := newPipe(c, h)
+
+	a := NewAsterisk(&cln, nil)
+	c.Assert(a.Login("admin", "admin"), check.IsNil)
+
+	return a, srv
+}
+
+// newPipe, in-memory connection served by mock
+func newPipe(c *check.C, h func(Message) []Message) (net.Conn, net.Conn) {
+	cln, srv := net.Pipe()
+	go servePipe(srv, h)
+
+	return cln, srv
+}
+
+// servePipe, mock Asterisk side of connection
+func servePipe(conn net.Conn, h func(Message) []Message) {
+	r := bufio.NewReader(conn)
+	m := Message{}
+
+	for {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		l = strings.TrimRight(l, "\r\n")
+		if l != "" {
+			kv := strings.SplitN(l, ": ", 2)
+			if len(kv) == 2 {
+				m[kv[0]] = kv[1]
+			}
+			continue
+		}
+
+		rl := h(m)
+		if rl == nil && m["Action"] == "Login" {
+			rl = []Message{{"Response": "Success", "Message": "Authentication accepted"}}
+		}
+
+		for _, rm := range rl {
+			if _, ok := rm["ActionID"]; !ok {
+				rm["ActionID"] = m["ActionID"]
+			}
+			raw := ""
+			for k, v := range rm {
+				raw += k + ": " + v + "\r\n"
+			}
+			if _, err := conn.Write([]byte(raw + "\r\n")); err != nil {
+				return
+			}
+		}
+		m = Message{}
+	}
+}
+
 type amock struct {
 	ln net.Listener
 }
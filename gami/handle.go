@@ -0,0 +1,157 @@
+package gami
+
+import (
+	"context"
+	"fmt"
+)
+
+// func-value API, handlers are plain functions, registrations are removed by returned handles
+// (pointer based methods are thin wrappers kept for compatibility)
+
+// ActionHandle, action callback registration
+type ActionHandle struct {
+	aid string  // action id
+	cl  *cbList // owner storage
+}
+
+// ActionID, returns ActionID of sent action
+func (h *ActionHandle) ActionID() string {
+
+	return h.aid
+}
+
+// Cancel, removes callback, later responses are ignored (safe to call more than once)
+func (h *ActionHandle) Cancel() {
+
+	h.cl.del(h.aid)
+}
+
+// deref, value of pointer callback (nil - zero value), used by pointer based wrappers
+func deref[F any](f *F) F {
+
+	var v F
+	if f != nil {
+		v = *f
+	}
+
+	return v
+}
+
+// sendAction, sends action with callback (hold - kept until canceled), removes callback if
+// action is not sent
+func (a *Asterisk) sendAction(m Message, f func(Message), hold bool) (*ActionHandle, error) {
+
+	if !a.isAuthorized() {
+		return nil, ErrNotAuthorized
+	}
+
+	if hold && f == nil {
+		return nil, ErrNilCallback
+	}
+
+	aid := a.aid.Generate()
+	m["ActionID"] = aid
+
+	if f != nil {
+		a.actionHandlers.set(aid, &f, hold)
+	}
+
+	if err := a.send(context.Background(), m); err != nil {
+		a.actionHandlers.del(aid)
+		return nil, err
+	}
+
+	return &ActionHandle{aid, a.actionHandlers}, nil
+}
+
+// Send, send action, f (nil - no callback) runs once for response
+func (a *Asterisk) Send(m Message, f func(Message)) (*ActionHandle, error) {
+
+	return a.sendAction(m, f, false)
+}
+
+// SendHold, send action, f runs for every message with its ActionID until handle is canceled
+// (multi-message responses, see CollectList for EventList actions)
+func (a *Asterisk) SendHold(m Message, f func(Message)) (*ActionHandle, error) {
+
+	return a.sendAction(m, f, true)
+}
+
+// On, register handler for event (any number of handlers per event), removed by Unsubscribe
+// of returned handle
+func (a *Asterisk) On(event string, f func(Message)) *Subscription {
+
+	s := &Subscription{
+		event: event,
+		f:     f,
+		sl:    a.subscriptions,
+	}
+	a.subscriptions.add(s)
+
+	return s
+}
+
+// OnFilter, register handler for events matching filter, returns error for malformed event pattern
+func (a *Asterisk) OnFilter(ef *EventFilter, f func(Message)) (*Subscription, error) {
+
+	return a.subscribeFilter(ef, f, false)
+}
+
+// register, one handler per event registration (RegisterHandler)
+func (a *Asterisk) register(event string, f func(Message)) error {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.registered[event]; ok {
+		return fmt.Errorf("%w: %s", ErrHandlerExists, event)
+	}
+	a.registered[event] = a.On(event, f)
+
+	return nil
+}
+
+// unregister, removes handler set by register
+func (a *Asterisk) unregister(event string) {
+
+	a.mu.Lock()
+	s := a.registered[event]
+	delete(a.registered, event)
+	a.mu.Unlock()
+
+	if s != nil {
+		s.Unsubscribe()
+	}
+}
+
+// OnMessage, set default handler for all Asterisk messages (nil - remove)
+func (a *Asterisk) OnMessage(f func(Message)) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.defaultHandler = f
+}
+
+// OnRaw, set handler for all packets with headers in wire order (nil - remove)
+func (a *Asterisk) OnRaw(f func(Headers)) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rawHandler = f
+}
+
+// OnError, set hook for handler failures (*HandlerPanic), nil - log
+func (a *Asterisk) OnError(f func(error)) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.errHandler = f
+}
+
+// OnState, set connection state handler (Disconnected, Reconnected, ReconnectFailed), nil - remove
+func (a *Asterisk) OnState(f func(ConnState, error)) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.stateHandler = f
+}
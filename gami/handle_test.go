@@ -0,0 +1,132 @@
+package gami
+
+import (
+	"errors"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *UnitSuite) TestFuncHandlers(c *check.C) {
+	a, srv := newPipeAsterisk(c, func(m Message) []Message {
+		switch m["Action"] {
+		case "Ping":
+			return []Message{{"Response": "Success", "Ping": "Pong"}}
+		case "Status":
+			return []Message{
+				{"Response": "Success", "EventList": "start"},
+				{"Event": "Status", "Channel": "SIP/1"},
+				{"Event": "StatusComplete", "EventList": "Complete"},
+			}
+		case "Wait":
+			return []Message{} // no response
+		}
+		return nil
+	})
+	defer srv.Close()
+
+	ch := make(chan Message, 10)
+
+	// action callback runs once
+	h, err := a.Send(Message{"Action": "Ping"}, func(m Message) { ch <- m })
+	c.Assert(err, check.IsNil)
+	c.Assert(h.ActionID(), check.Not(check.Equals), "")
+	c.Assert(expectMessages(c, ch, 1)[0]["Ping"], check.Equals, "Pong")
+
+	// canceled callback never runs
+	h, err = a.Send(Message{"Action": "Wait"}, func(m Message) { ch <- m })
+	c.Assert(err, check.IsNil)
+	h.Cancel()
+	h.Cancel()
+	f, _ := a.actionHandlers.get(h.ActionID())
+	c.Assert(f, check.IsNil)
+
+	// hold callback runs for every message until canceled
+	var hh *ActionHandle
+	done := make(chan *ActionHandle, 1)
+	hh, err = a.SendHold(Message{"Action": "Status"}, func(m Message) {
+		ch <- m
+		if listEnd(m) {
+			(<-done).Cancel()
+		}
+	})
+	c.Assert(err, check.IsNil)
+	done <- hh
+	c.Assert(expectMessages(c, ch, 3)[2]["Event"], check.Equals, "StatusComplete")
+	_, err = a.SendHold(Message{"Action": "Status"}, nil)
+	c.Assert(err, check.Equals, ErrNilCallback)
+
+	// event handlers are removed by handles
+	sub := a.On("Hangup", func(m Message) { ch <- m })
+	srv.Write([]byte("Event: Hangup\r\nChannel: SIP/1\r\n\r\n"))
+	c.Assert(expectMessages(c, ch, 1)[0]["Channel"], check.Equals, "SIP/1")
+	sub.Unsubscribe()
+
+	_, err = a.OnFilter(&EventFilter{Event: "["}, func(Message) {})
+	c.Assert(err, check.NotNil)
+
+	dh := make(chan Message, 10)
+	a.OnMessage(func(m Message) { dh <- m })
+	srv.Write([]byte("Event: Hangup\r\nChannel: SIP/2\r\n\r\n"))
+	c.Assert(expectMessages(c, dh, 1)[0]["Channel"], check.Equals, "SIP/2")
+	expectNothing(c, ch)
+
+	a.OnMessage(nil)
+	srv.Write([]byte("Event: Hangup\r\nChannel: SIP/3\r\n\r\n"))
+	expectNothing(c, dh)
+
+	// hooks
+	eh := make(chan error, 1)
+	a.OnError(func(err error) { eh <- err })
+	a.On("Panic", func(Message) { panic("boom") })
+	srv.Write([]byte("Event: Panic\r\n\r\n"))
+	var hp *HandlerPanic
+	c.Assert(errors.As(<-eh, &hp), check.Equals, true)
+
+	st := make(chan ConnState, 1)
+	a.OnState(func(s ConnState, err error) { st <- s })
+	srv.Close()
+	c.Assert(<-st, check.Equals, Disconnected)
+
+	// not sent, nothing registered
+	_, err = a.Send(Message{"Action": "Ping", "ActionID": "custom"}, func(Message) {})
+	c.Assert(err, check.Equals, ErrNotAuthorized)
+	c.Assert(a.actionHandlers.f, check.HasLen, 0)
+}
+
+func (s *UnitSuite) TestPointerWrappers(c *check.C) {
+	a, srv := newPipeAsterisk(c, func(m Message) []Message {
+		if m["Action"] == "Ping" {
+			return []Message{{"Response": "Success", "Ping": "Pong"}}
+		}
+		return nil
+	})
+	defer srv.Close()
+
+	ch := make(chan Message, 10)
+	f := func(m Message) { ch <- m }
+
+	c.Assert(a.SendAction(Message{"Action": "Ping"}, &f), check.IsNil)
+	c.Assert(expectMessages(c, ch, 1)[0]["Ping"], check.Equals, "Pong")
+
+	// RegisterHandler is one subscription per event, On handlers of same event are kept
+	rh := make(chan Message, 10)
+	g := func(m Message) { rh <- m }
+	c.Assert(a.RegisterHandler("Hangup", &g), check.IsNil)
+	c.Assert(errors.Is(a.RegisterHandler("Hangup", &g), ErrHandlerExists), check.Equals, true)
+	sub := a.On("Hangup", f)
+	srv.Write([]byte("Event: Hangup\r\n\r\n"))
+	expectMessages(c, rh, 1)
+	expectMessages(c, ch, 1)
+
+	a.UnregisterHandler("Hangup")
+	srv.Write([]byte("Event: Hangup\r\n\r\n"))
+	expectMessages(c, ch, 1)
+	expectNothing(c, rh)
+	c.Assert(a.RegisterHandler("Hangup", &g), check.IsNil)
+	sub.Unsubscribe()
+
+	a.DefaultHandler(&f)
+	srv.Write([]byte("Event: Newchannel\r\n\r\n"))
+	c.Assert(expectMessages(c, ch, 1)[0]["Event"], check.Equals, "Newchannel")
+	a.DefaultHandler(nil)
+}
@@ -0,0 +1,151 @@
+package gami
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
+const (
+	_MULTI_SEP = "\n" // separator of repeated header values in Message view
+)
+
+// Header, single packet header line
+type Header struct {
+	Key   string
+	Value string
+}
+
+// Headers, packet headers in wire order (repeated keys allowed), key lookup is case insensitive
+type Headers []Header
+
+// Get, returns first value for key
+func (h Headers) Get(key string) string {
+
+	for _, v := range h {
+		if strings.EqualFold(v.Key, key) {
+			return v.Value
+		}
+	}
+
+	return ""
+}
+
+// Values, returns all values for key in wire order
+func (h Headers) Values(key string) []string {
+
+	var vl []string
+	for _, v := range h {
+		if strings.EqualFold(v.Key, key) {
+			vl = append(vl, v.Value)
+		}
+	}
+
+	return vl
+}
+
+// Add, appends header
+func (h *Headers) Add(key, value string) {
+
+	*h = append(*h, Header{key, value})
+}
+
+// Del, removes all headers with key
+func (h *Headers) Del(key string) {
+
+	nh := (*h)[:0]
+	for _, v := range *h {
+		if !strings.EqualFold(v.Key, key) {
+			nh = append(nh, v)
+		}
+	}
+
+	*h = nh
+}
+
+// Set, replaces all headers with key by single header (in place of first one)
+func (h *Headers) Set(key, value string) {
+
+	for i, v := range *h {
+		if strings.EqualFold(v.Key, key) {
+			(*h)[i].Value = value
+			rest := (*h)[i+1:]
+			rest.Del(key)
+			*h = (*h)[:i+1+len(rest)]
+			return
+		}
+	}
+
+	h.Add(key, value)
+}
+
+// Message, map view of headers, repeated header values are joined with "\n" (see Message.Values)
+func (h Headers) Message() Message {
+
+	m := make(Message, len(h))
+	for _, v := range h {
+		if pv, ok := m[v.Key]; ok {
+			m[v.Key] = pv + _MULTI_SEP + v.Value
+		} else {
+			m[v.Key] = v.Value
+		}
+	}
+
+	return m
+}
+
+// Values, returns all values of repeated header ("\n" separated in map view)
+func (m Message) Values(key string) []string {
+
+	v, ok := m[key]
+	if !ok {
+		return nil
+	}
+
+	return strings.Split(v, _MULTI_SEP)
+}
+
+// Headers, ordered headers for sending, Action and ActionID go first, other keys sorted,
+// "\n" separated values are sent as repeated headers (line break is never part of map value,
+// AMI header can not carry it, Headers.marshal replaces it with space)
+func (m Message) Headers() Headers {
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		if k != "Action" && k != "ActionID" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range []string{"ActionID", "Action"} {
+		if _, ok := m[k]; ok {
+			keys = append([]string{k}, keys...)
+		}
+	}
+
+	h := make(Headers, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range m.Values(k) {
+			h.Add(k, v)
+		}
+	}
+
+	return h
+}
+
+// marshal, packet bytes, line breaks inside values are replaced by spaces
+func (h Headers) marshal() []byte {
+
+	buf := bytes.NewBufferString("")
+
+	for _, v := range h {
+		buf.WriteString(v.Key)
+		buf.WriteString(_KEY_VAL_TERM)
+		buf.WriteString(strings.NewReplacer("\r", " ", "\n", " ").Replace(v.Value))
+		buf.WriteString(_LINE_TERM)
+	}
+	buf.WriteString(_LINE_TERM)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,106 @@
+package gami
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *UnitSuite) TestHeaders(c *check.C) {
+	h := Headers{}
+	h.Add("Action", "Originate")
+	h.Add("Variable", "a=1")
+	h.Add("Variable", "b=2")
+	h.Add("Channel", "SIP/1")
+
+	c.Assert(h.Get("variable"), check.Equals, "a=1")
+	c.Assert(h.Values("Variable"), check.DeepEquals, []string{"a=1", "b=2"})
+
+	m := h.Message()
+	c.Assert(m["Variable"], check.Equals, "a=1\nb=2")
+	c.Assert(m.Values("Variable"), check.DeepEquals, []string{"a=1", "b=2"})
+	c.Assert(m.Values("Unknown"), check.IsNil)
+
+	h.Set("Variable", "c=3")
+	c.Assert(h, check.DeepEquals, Headers{{"Action", "Originate"}, {"Variable", "c=3"}, {"Channel", "SIP/1"}})
+	h.Del("Channel")
+	h.Set("ActionID", "1")
+	c.Assert(h, check.DeepEquals, Headers{{"Action", "Originate"}, {"Variable", "c=3"}, {"ActionID", "1"}})
+}
+
+func (s *UnitSuite) TestMessageHeaders(c *check.C) {
+	m := Message{"Zeta": "z", "ActionID": "1", "Variable": "a=1\nb=2", "Action": "Originate", "Bad": "x\r"}
+	h := m.Headers()
+	c.Assert(h, check.DeepEquals, Headers{
+		{"Action", "Originate"}, {"ActionID", "1"}, {"Bad", "x\r"},
+		{"Variable", "a=1"}, {"Variable", "b=2"}, {"Zeta", "z"},
+	})
+	c.Assert(string(h.marshal()), check.Equals,
+		"Action: Originate\r\nActionID: 1\r\nBad: x \r\nVariable: a=1\r\nVariable: b=2\r\nZeta: z\r\n\r\n")
+
+	// multi-line text is not altered, it is sent base64 encoded
+	m = messageSendAction("pjsip:100", "200", "line 1\nline 2", false, nil)
+	c.Assert(m["Body"], check.Equals, "")
+	c.Assert(m.Headers().Values("Base64Body"), check.DeepEquals, []string{"bGluZSAxCmxpbmUgMg=="})
+	m = messageSendAction("pjsip:100", "200", "line 1\r\nline 2", false, nil)
+	c.Assert(m.Headers().Values("Base64Body"), check.HasLen, 1)
+	m = messageSendAction("pjsip:100", "200", "line 1", false, nil)
+	c.Assert(m.Headers().Values("Body"), check.DeepEquals, []string{"line 1"})
+	m = messageSendAction("pjsip:100", "200", "line 1", true, nil)
+	c.Assert(m.Headers().Values("Base64Body"), check.DeepEquals, []string{"bGluZSAx"})
+
+	h = Headers{{"Action", "MessageSend"}}
+	h.Add("Body", "line 1\nline 2")
+	c.Assert(h.Values("Body"), check.HasLen, 1)
+	c.Assert(string(h.marshal()), check.Equals, "Action: MessageSend\r\nBody: line 1 line 2\r\n\r\n")
+}
+
+func (s *UnitSuite) TestHeadersWire(c *check.C) {
+	cln, srv := net.Pipe()
+	defer srv.Close()
+
+	a := NewAsterisk(&cln, nil)
+	a.setAuthorized(true)
+	go a.readDispatcher(cln, make(chan struct{}), make(chan struct{}))
+
+	raw := make(chan Headers, 1)
+	rf := func(h Headers) { raw <- h }
+	a.RawHandler(&rf)
+
+	// server side: capture request, answer with repeated headers
+	req := make(chan []string, 1)
+	go func() {
+		r := bufio.NewReader(srv)
+		lines := []string{}
+		for {
+			l, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			l = strings.TrimRight(l, "\r\n")
+			if l == "" {
+				break
+			}
+			lines = append(lines, l)
+		}
+		req <- lines
+		aid := strings.TrimPrefix(lines[len(lines)-1], "ActionID: ")
+		srv.Write([]byte("Response: Success\r\nActionID: " + aid + "\r\nOutput: line 1\r\nOutput: line 2\r\n\r\n"))
+	}()
+
+	r, err := a.SendHeadersContext(context.Background(), Headers{
+		{"Action", "UserEvent"}, {"UserEvent", "Test"}, {"Key", "1"}, {"Key", "2"},
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(r.Values("Output"), check.DeepEquals, []string{"line 1", "line 2"})
+
+	lines := <-req
+	c.Assert(lines[:4], check.DeepEquals, []string{"Action: UserEvent", "UserEvent: Test", "Key: 1", "Key: 2"})
+
+	h := <-raw
+	c.Assert(h[0], check.Equals, Header{"Response", "Success"})
+	c.Assert(h.Values("Output"), check.DeepEquals, []string{"line 1", "line 2"})
+}
@@ -0,0 +1,91 @@
+package gami
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// SetKeepalive, send Ping every interval while logged in (should be called before Login),
+// Pong not received in timeout (0 - interval) drops connection with ErrKeepalive,
+// network error handler and reconnect are run as for any network error, interval 0 - disabled
+func (a *Asterisk) SetKeepalive(interval, timeout time.Duration) {
+
+	if timeout <= 0 {
+		timeout = interval
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.kaInterval = interval
+	a.kaTimeout = timeout
+}
+
+// Latency, returns round-trip time of last keepalive Ping (0 if not measured)
+func (a *Asterisk) Latency() time.Duration {
+
+	return time.Duration(atomic.LoadInt64(a.rtt))
+}
+
+// startKeepalive, starts pinger for current session (called by Login)
+func (a *Asterisk) startKeepalive() {
+
+	a.mu.RLock()
+	interval, timeout, done := a.kaInterval, a.kaTimeout, a.sessionDone
+	a.mu.RUnlock()
+
+	if interval > 0 {
+		go a.keepalive(interval, timeout, done)
+	}
+}
+
+// keepalive, pings Asterisk until session is done
+func (a *Asterisk) keepalive(interval, timeout time.Duration, done chan struct{}) {
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-done:
+			return
+		case <-a.closed:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		start := time.Now()
+		_, err := a.SendActionContext(ctx, Message{"Action": "Ping"})
+		cancel()
+
+		var ae *AMIError
+		switch {
+		case err == nil:
+		case errors.As(err, &ae): // Asterisk answered, link is alive
+		case errors.Is(err, ErrConnectionClosed), errors.Is(err, ErrNotAuthorized):
+			return // session already lost
+		default:
+			a.dropConnection(done, fmt.Errorf("%w: %w", ErrKeepalive, err))
+			return
+		}
+
+		atomic.StoreInt64(a.rtt, int64(time.Since(start)))
+	}
+}
+
+// dropConnection, closes connection of session, read dispatcher reports err as network error
+func (a *Asterisk) dropConnection(done chan struct{}, err error) {
+
+	a.mu.Lock()
+	if a.sessionDone != done { // session already lost
+		a.mu.Unlock()
+		return
+	}
+	a.dropErr = err
+	a.mu.Unlock()
+
+	a.connection().Close()
+}
@@ -0,0 +1,96 @@
+package gami
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *UnitSuite) TestKeepalive(c *check.C) {
+	pings := new(int32)
+	cln, srv := newPipe(c, func(m Message) []Message {
+		if m["Action"] == "Ping" {
+			if atomic.AddInt32(pings, 1) > 3 { // link is dead after 3 pongs
+				return []Message{}
+			}
+			return []Message{{"Response": "Success", "Ping": "Pong"}}
+		}
+		return nil
+	})
+	defer srv.Close()
+
+	netErr := make(chan error, 1)
+	nf := func(err error) { netErr <- err }
+	a := NewAsterisk(&cln, &nf)
+	a.SetKeepalive(20*time.Millisecond, 50*time.Millisecond)
+	c.Assert(a.Latency(), check.Equals, time.Duration(0))
+	c.Assert(a.Login("admin", "admin"), check.IsNil)
+
+	select {
+	case err := <-netErr:
+		c.Assert(errors.Is(err, ErrKeepalive), check.Equals, true)
+		c.Assert(errors.Is(err, ErrTimeout), check.Equals, true)
+	case <-time.After(2 * time.Second):
+		c.Fatal("dead connection not detected")
+	}
+
+	c.Assert(atomic.LoadInt32(pings), check.Equals, int32(4))
+	c.Assert(a.Latency() > 0, check.Equals, true)
+	c.Assert(a.isAuthorized(), check.Equals, false)
+}
+
+func (s *UnitSuite) TestKeepaliveReconnect(c *check.C) {
+	dials := make(chan net.Conn, 2)
+	dial := func() (net.Conn, error) {
+		n := len(dials)
+		cln, srv := newPipe(c, func(m Message) []Message {
+			switch {
+			case m["Action"] == "Logoff":
+				return []Message{{"Response": "Goodbye"}}
+			case m["Action"] != "Ping":
+				return nil
+			case n == 0: // first connection never answers
+				return []Message{}
+			}
+			return []Message{{"Response": "Success", "Ping": "Pong"}}
+		})
+		dials <- srv
+		return cln, nil
+	}
+
+	a, err := NewReconnectAsterisk(dial, &Reconnect{MinDelay: time.Millisecond, MaxDelay: time.Millisecond}, nil)
+	c.Assert(err, check.IsNil)
+
+	st := make(chan error, 2)
+	a.OnState(func(s ConnState, err error) {
+		if s == Disconnected {
+			st <- err
+		}
+	})
+	a.SetKeepalive(20*time.Millisecond, 0)
+	c.Assert(a.Login("admin", "admin"), check.IsNil)
+
+	select {
+	case err := <-st:
+		c.Assert(errors.Is(err, ErrKeepalive), check.Equals, true)
+	case <-time.After(2 * time.Second):
+		c.Fatal("dead connection not detected")
+	}
+
+	// reconnected, keepalive runs on new connection
+	deadline := time.Now().Add(2 * time.Second)
+	for a.Latency() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(a.Latency() > 0, check.Equals, true)
+	c.Assert(len(dials), check.Equals, 2)
+
+	c.Assert(a.Close(context.Background()), check.IsNil)
+	for len(dials) > 0 {
+		(<-dials).Close()
+	}
+}
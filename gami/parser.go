@@ -0,0 +1,177 @@
+package gami
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+const (
+	_BANNER_PREFIX = "Asterisk Call Manager" // first line sent by Asterisk
+	_MAX_PACKET    = 1 << 20                 // default packet size limit
+)
+
+// followsHeaders, headers Asterisk writes after "Response: Follows", other lines are output
+// ("database show" lines look like headers: "/SIP/Registry/100      : 10.0.0.1:5060")
+var followsHeaders = map[string]bool{
+	"Privilege": true,
+	"ActionID":  true,
+}
+
+// parser, reads AMI packets from stream line by line
+type parser struct {
+	r       *bufio.Reader
+	max     int          // packet size limit, bigger packets are skipped
+	banner  string       // Asterisk greeting line
+	first   bool         // next line is first line of stream
+	onFirst func(string) // called on first line with banner ("" if stream has no banner)
+}
+
+// newParser, parser factory
+func newParser(r io.Reader, max int) *parser {
+
+	if max <= 0 {
+		max = _MAX_PACKET
+	}
+
+	return &parser{
+		r:     bufio.NewReaderSize(r, _READ_BUF),
+		max:   max,
+		first: true,
+	}
+}
+
+// readLine, returns line without terminator ("\r\n" or "\n"), line is truncated to limit
+// bytes (rest is skipped), n is full line length, line is valid till next read
+func (p *parser) readLine(limit int) (line []byte, n int, err error) {
+
+	for {
+		chunk, err := p.r.ReadSlice('\n')
+		n += len(chunk)
+
+		if err == nil && line == nil && len(chunk) <= limit { // whole line in buffer, no copy
+			return bytes.TrimRight(chunk, "\r\n"), n, nil
+		}
+
+		if room := limit - len(line); room > 0 {
+			if len(chunk) > room {
+				line = append(line, chunk[:room]...)
+			} else {
+				line = append(line, chunk...)
+			}
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, n, err
+		}
+
+		return bytes.TrimRight(line, "\r\n"), n, nil
+	}
+}
+
+// splitHeader, splits "Key: Value" line, ok is false for not header line
+// (strict: key without spaces, used for command output)
+func splitHeader(line []byte, strict bool) (key, value string, ok bool) {
+
+	i := bytes.IndexByte(line, ':')
+	if i <= 0 {
+		return "", "", false
+	}
+
+	k := bytes.TrimSpace(line[:i])
+	v := line[i+1:]
+
+	if len(v) > 0 && v[0] != ' ' { // "Key:value" is not AMI header (time "12:00", URIs)
+		return "", "", false
+	}
+	if len(k) == 0 || strict && bytes.ContainsAny(k, " \t") {
+		return "", "", false
+	}
+
+	v = bytes.TrimRight(bytes.TrimPrefix(v, []byte(" ")), " \t")
+
+	return string(k), string(v), true
+}
+
+// next, returns next packet, ErrPacketTooLarge for skipped oversized packet (stream is still usable),
+// other errors are stream errors
+func (p *parser) next() (Headers, error) {
+
+	var h Headers
+	size := 0
+	follows := false // Response: Follows, command output till _CMD_END
+	output := false  // command output started, all lines are data
+	skip := false    // packet too large, skip till end
+
+	for {
+		line, n, err := p.readLine(p.max + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		if p.first {
+			p.first = false
+			banner := bytes.HasPrefix(line, []byte(_BANNER_PREFIX))
+			if banner {
+				p.banner = string(line)
+			}
+			if p.onFirst != nil {
+				p.onFirst(p.banner)
+			}
+			if banner {
+				continue
+			}
+		}
+
+		size += n
+		if size > p.max {
+			skip = true
+		}
+
+		if follows {
+			end := false
+			if i := bytes.Index(line, []byte(_CMD_END)); i != -1 {
+				line, end = line[:i], true
+			}
+
+			if !skip {
+				if k, v, ok := splitHeader(line, true); ok && !output && followsHeaders[k] {
+					h.Add(k, v)
+				} else if !end || len(line) > 0 {
+					output = true
+					h.Add("CmdData", string(line))
+				}
+			}
+
+			follows = !end
+			continue
+		}
+
+		if len(line) == 0 { // end of packet
+			if skip {
+				return nil, ErrPacketTooLarge
+			}
+			if len(h) == 0 { // extra empty line
+				size = 0
+				continue
+			}
+			return h, nil
+		}
+
+		if skip {
+			continue
+		}
+
+		if k, v, ok := splitHeader(line, false); ok {
+			h.Add(k, v)
+			if k == "Response" && v == "Follows" {
+				follows = true
+			}
+		} else {
+			h.Add("CmdData", string(line))
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package gami
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	check "gopkg.in/check.v1"
+)
+
+// parseAll, returns all packets and final error of stream
+func parseAll(s string, max int) ([]Headers, []error) {
+	p := newParser(strings.NewReader(s), max)
+	hl := []Headers{}
+	el := []error{}
+	for {
+		h, err := p.next()
+		if err == io.EOF {
+			return hl, el
+		}
+		if err != nil {
+			el = append(el, err)
+			if err != ErrPacketTooLarge {
+				return hl, el
+			}
+			continue
+		}
+		hl = append(hl, h)
+	}
+}
+
+func (s *UnitSuite) TestParserBanner(c *check.C) {
+	p := newParser(strings.NewReader("Asterisk Call Manager/5.0.1\r\nResponse: Success\r\nMessage: Authentication accepted\r\n\r\n"), 0)
+	h, err := p.next()
+	c.Assert(err, check.IsNil)
+	c.Assert(p.banner, check.Equals, "Asterisk Call Manager/5.0.1")
+	c.Assert(h, check.DeepEquals, Headers{{"Response", "Success"}, {"Message", "Authentication accepted"}})
+}
+
+func (s *UnitSuite) TestParserValues(c *check.C) {
+	hl, el := parseAll("Event: Test\r\nMessage: a: b: c\r\nEmpty:\r\nURI: sip:100@host\r\nOutput:    indented  \r\n\r\n", 0)
+	c.Assert(el, check.HasLen, 0)
+	c.Assert(hl, check.DeepEquals, []Headers{{
+		{"Event", "Test"}, {"Message", "a: b: c"}, {"Empty", ""}, {"URI", "sip:100@host"}, {"Output", "   indented"},
+	}})
+}
+
+func (s *UnitSuite) TestParserMalformed(c *check.C) {
+	hl, el := parseAll("\r\n\r\nResponse: Success\nKey:value\ngarbage line\r\n: no key\r\n\n\r\n\r\nEvent: Next\r\n\r\nEvent: Cut\r\nChan", 0)
+	c.Assert(el, check.HasLen, 0) // EOF in the middle of packet
+	c.Assert(hl, check.DeepEquals, []Headers{
+		{{"Response", "Success"}, {"CmdData", "Key:value"}, {"CmdData", "garbage line"}, {"CmdData", ": no key"}},
+		{{"Event", "Next"}},
+	})
+	c.Assert(hl[0].Message()["CmdData"], check.Equals, "Key:value\ngarbage line\n: no key")
+}
+
+func (s *UnitSuite) TestParserFollows(c *check.C) {
+	out := "Response: Follows\r\nPrivilege: Command\r\nActionID: 1\r\n" +
+		"Channel              Location\n" +
+		"\n" +
+		"System uptime: 1 day\n" +
+		"Key: looks like header\n" +
+		"--END COMMAND--\r\n\r\n" +
+		"Response: Follows\r\nActionID: 2\r\nNo newline--END COMMAND--\r\n\r\n"
+
+	hl, el := parseAll(out, 0)
+	c.Assert(el, check.HasLen, 0)
+	c.Assert(hl, check.HasLen, 2)
+	c.Assert(hl[0].Get("ActionID"), check.Equals, "1")
+	c.Assert(hl[0].Values("CmdData"), check.DeepEquals, []string{
+		"Channel              Location", "", "System uptime: 1 day", "Key: looks like header",
+	})
+	c.Assert(hl[1].Values("CmdData"), check.DeepEquals, []string{"No newline"})
+
+	// Asterisk 13 "database show", output starts with header-like lines
+	out = "Response: Follows\r\nPrivilege: Command\r\nActionID: 3\r\n" +
+		"/SIP/Registry/100                                 : 10.0.0.1:5060:3600:100\n" +
+		"/pbx/UUID                                         : 4f1a\n" +
+		"2 results found.\n" +
+		"--END COMMAND--\r\n\r\n"
+
+	hl, el = parseAll(out, 0)
+	c.Assert(el, check.HasLen, 0)
+	c.Assert(hl, check.HasLen, 1)
+	c.Assert(hl[0].Get("ActionID"), check.Equals, "3")
+	c.Assert(hl[0].Get("/SIP/Registry/100"), check.Equals, "")
+	c.Assert(commandOutput(hl[0].Message()), check.DeepEquals, []string{
+		"/SIP/Registry/100                                 : 10.0.0.1:5060:3600:100",
+		"/pbx/UUID                                         : 4f1a",
+		"2 results found.",
+	})
+}
+
+func (s *UnitSuite) TestParserLimits(c *check.C) {
+	long := strings.Repeat("x", 3*_READ_BUF)
+	hl, el := parseAll("Event: Long\r\nData: "+long+"\r\n\r\n", 0)
+	c.Assert(el, check.HasLen, 0)
+	c.Assert(hl[0].Get("Data"), check.Equals, long)
+
+	huge := "Event: Huge\r\nData: " + strings.Repeat("y", 1000) + "\r\n\r\n"
+	many := "Event: Many\r\n" + strings.Repeat("Var: z\r\n", 200) + "\r\n"
+	hl, el = parseAll(huge+many+"Event: Small\r\n\r\n", 512)
+	c.Assert(el, check.DeepEquals, []error{ErrPacketTooLarge, ErrPacketTooLarge})
+	c.Assert(hl, check.DeepEquals, []Headers{{{"Event", "Small"}}})
+}
+
+// benchStream, typical events stream
+func benchStream(n int) []byte {
+	ev := "Event: Newexten\r\nPrivilege: dialplan,all\r\nChannel: PJSIP/100-00000001\r\n" +
+		"ChannelState: 6\r\nChannelStateDesc: Up\r\nCallerIDNum: 100\r\nContext: default\r\n" +
+		"Exten: 200\r\nPriority: 1\r\nUniqueid: 1600000000.1\r\nLinkedid: 1600000000.1\r\n" +
+		"Application: Dial\r\nAppData: PJSIP/200,30,tT\r\n\r\n"
+	return []byte(strings.Repeat(ev, n))
+}
+
+func (s *UnitSuite) BenchmarkParser(c *check.C) {
+	data := benchStream(c.N)
+	c.SetBytes(int64(len(data)) / int64(c.N))
+	c.ResetTimer()
+
+	p := newParser(bytes.NewReader(data), 0)
+	for i := 0; i < c.N; i++ {
+		if _, err := p.next(); err != nil {
+			c.Fatal(err)
+		}
+	}
+}
+
+func (s *UnitSuite) BenchmarkParserMessage(c *check.C) {
+	data := benchStream(c.N)
+	c.SetBytes(int64(len(data)) / int64(c.N))
+	c.ResetTimer()
+
+	p := newParser(bytes.NewReader(data), 0)
+	for i := 0; i < c.N; i++ {
+		h, err := p.next()
+		if err != nil {
+			c.Fatal(err)
+		}
+		h.Message()
+	}
+}
@@ -0,0 +1,291 @@
+package gami
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	_TAG         = "ami"                 // struct field tag
+	_TAG_REST    = "rest"                // tag option of catch-all map field
+	_TIME_LAYOUT = "2006-01-02 15:04:05" // CDR/CEL time format
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// Unmarshal, decodes message headers into struct pointed by v
+// field is filled from header named by `ami:"Header"` tag (field name if no tag, "-" skipped),
+// names are case-insensitive, missing and empty headers leave field unchanged
+// supported types (and pointers to them):
+//
+//	string, []string (repeated header values)
+//	ints, uints, floats
+//	bool (yes/no, true/false, 1/0, on/off)
+//	time.Duration (seconds "35" or "1.5", "01:02:03", Go syntax "1m30s")
+//	time.Time (Unix timestamp "1521635281.123456", RFC3339 or "2006-01-02 15:04:05" in local time)
+//
+// embedded structs are decoded from same message (nil embedded pointer is allocated if any of
+// its headers is present), exported map[string]string field tagged `ami:",rest"` gets headers
+// not decoded into other fields
+func Unmarshal(m Message, v any) error {
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%w: non-nil struct pointer required, got %T", ErrUnmarshal, v)
+	}
+
+	used := make(map[string]bool) // decoded headers (lower case)
+	var rest reflect.Value
+
+	if _, err := unmarshalStruct(m, rv.Elem(), used, &rest); err != nil {
+		return err
+	}
+
+	if !rest.IsValid() {
+		return nil
+	}
+
+	if rest.IsNil() {
+		rest.Set(reflect.MakeMap(rest.Type()))
+	}
+	for k, val := range m {
+		if !used[strings.ToLower(k)] {
+			rest.SetMapIndex(reflect.ValueOf(k), reflect.ValueOf(val))
+		}
+	}
+
+	return nil
+}
+
+// unmarshalStruct, decodes headers into struct fields, returns number of present headers
+func unmarshalStruct(m Message, sv reflect.Value, used map[string]bool, rest *reflect.Value) (int, error) {
+
+	st := sv.Type()
+	found := 0
+
+	for i := 0; i < st.NumField(); i++ {
+		sf := st.Field(i)
+		tag, hasTag := sf.Tag.Lookup(_TAG)
+		if tag == "-" {
+			continue
+		}
+
+		name, opt, _ := strings.Cut(tag, ",")
+		fv := sv.Field(i)
+
+		if opt == _TAG_REST {
+			if sf.Type != reflect.TypeOf(map[string]string(nil)) || !sf.IsExported() {
+				return 0, fmt.Errorf("%w: %s rest field must be exported map[string]string", ErrUnmarshal, sf.Name)
+			}
+			*rest = fv
+			continue
+		}
+
+		if sf.Anonymous && !hasTag && sf.Type.Kind() == reflect.Struct && sf.Type != timeType {
+			n, err := unmarshalStruct(m, fv, used, rest)
+			if err != nil {
+				return 0, err
+			}
+			found += n
+			continue
+		}
+
+		if sf.Anonymous && !hasTag && sf.Type.Kind() == reflect.Pointer &&
+			sf.Type.Elem().Kind() == reflect.Struct && sf.Type.Elem() != timeType {
+			if !sf.IsExported() { // reflect can not set it
+				return 0, fmt.Errorf("%w: %s embedded pointer to unexported struct", ErrUnmarshal, sf.Name)
+			}
+
+			ev, hadRest := fv, rest.IsValid()
+			if fv.IsNil() {
+				ev = reflect.New(sf.Type.Elem())
+			}
+			n, err := unmarshalStruct(m, ev.Elem(), used, rest)
+			if err != nil {
+				return 0, err
+			}
+			if fv.IsNil() && (n > 0 || rest.IsValid() != hadRest) { // keep nil if nothing decoded
+				fv.Set(ev)
+			}
+			found += n
+			continue
+		}
+
+		if !sf.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = sf.Name
+		}
+
+		key, val, ok := lookupHeader(m, name)
+		if !ok {
+			continue
+		}
+		used[strings.ToLower(key)] = true
+		found++
+
+		if val == "" {
+			continue
+		}
+
+		if err := setField(fv, val); err != nil {
+			return 0, fmt.Errorf("%w: header %s value %q into %s: %v", ErrUnmarshal, key, val, sf.Type, err)
+		}
+	}
+
+	return found, nil
+}
+
+// lookupHeader, finds header by case-insensitive name
+func lookupHeader(m Message, name string) (string, string, bool) {
+
+	if v, ok := m[name]; ok {
+		return name, v, true
+	}
+
+	for k, v := range m {
+		if strings.EqualFold(k, name) {
+			return k, v, true
+		}
+	}
+
+	return "", "", false
+}
+
+// setField, converts header value to field type
+func setField(fv reflect.Value, val string) error {
+
+	if fv.Kind() == reflect.Pointer {
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		fv = fv.Elem()
+	}
+
+	switch fv.Type() {
+	case durationType:
+		d, err := parseDuration(val)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	case timeType:
+		t, err := parseTime(val)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(val)
+	case reflect.Bool:
+		b, err := parseBool(val)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(val), 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(strings.TrimSpace(val), 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type")
+		}
+		fv.Set(reflect.ValueOf(strings.Split(val, _MULTI_SEP)).Convert(fv.Type()))
+	default:
+		return fmt.Errorf("unsupported type")
+	}
+
+	return nil
+}
+
+// parseBool, AMI flag value
+func parseBool(val string) (bool, error) {
+
+	switch strings.ToLower(strings.TrimSpace(val)) {
+	case "yes", "true", "1", "on", "y", "t":
+		return true, nil
+	case "no", "false", "0", "off", "n", "f":
+		return false, nil
+	}
+
+	return false, fmt.Errorf("invalid flag")
+}
+
+// parseDuration, seconds, HH:MM:SS or Go duration
+func parseDuration(val string) (time.Duration, error) {
+
+	val = strings.TrimSpace(val)
+
+	if s, err := strconv.ParseFloat(val, 64); err == nil {
+		return time.Duration(s * float64(time.Second)), nil
+	}
+
+	if p := strings.Split(val, ":"); len(p) == 3 {
+		var d time.Duration
+		for i, u := range []time.Duration{time.Hour, time.Minute, time.Second} {
+			n, err := strconv.Atoi(p[i])
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid duration")
+			}
+			d += time.Duration(n) * u
+		}
+		return d, nil
+	}
+
+	return time.ParseDuration(val)
+}
+
+// parseTime, Unix timestamp (with fraction), RFC3339 or CDR time
+func parseTime(val string) (time.Time, error) {
+
+	val = strings.TrimSpace(val)
+
+	if sec, frac, _ := strings.Cut(val, "."); sec != "" {
+		if s, err := strconv.ParseInt(sec, 10, 64); err == nil {
+			var ns int64
+			if frac != "" {
+				if len(frac) > 9 {
+					frac = frac[:9]
+				}
+				if ns, err = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64); err != nil {
+					return time.Time{}, fmt.Errorf("invalid timestamp")
+				}
+			}
+			return time.Unix(s, ns), nil
+		}
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, val); err == nil {
+		return t, nil
+	}
+
+	return time.ParseInLocation(_TIME_LAYOUT, val, time.Local)
+}
@@ -0,0 +1,161 @@
+package gami
+
+import (
+	"errors"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+type testChannel struct {
+	Channel  string
+	Uniqueid string `ami:"UniqueID"`
+}
+
+type testHangup struct {
+	testChannel
+	Event     string
+	State     int               `ami:"ChannelState"`
+	Cause     *int              `ami:"Cause"`
+	Missing   *int              `ami:"Missing"`
+	Answered  bool              `ami:"Answered"`
+	Recording bool              `ami:"Recording"`
+	Duration  time.Duration     `ami:"Duration"`
+	BillSec   time.Duration     `ami:"BillableSeconds"`
+	Timestamp time.Time         `ami:"Timestamp"`
+	Start     time.Time         `ami:"StartTime"`
+	Vars      []string          `ami:"ChanVariable"`
+	Priority  uint8             `ami:"Priority"`
+	Skipped   string            `ami:"-"`
+	Other     map[string]string `ami:",rest"`
+}
+
+func (s *UnitSuite) TestUnmarshal(c *check.C) {
+	m := Message{
+		"Event":           "Hangup",
+		"Channel":         "SIP/100-00000001",
+		"Uniqueid":        "1521635281.12",
+		"ChannelState":    "6",
+		"Cause":           "16",
+		"Answered":        "yes",
+		"Recording":       "off",
+		"Duration":        "00:01:05",
+		"BillableSeconds": "42",
+		"Timestamp":       "1521635281.123456",
+		"StartTime":       "2018-03-21 12:28:01",
+		"ChanVariable":    "A=1\nB=2",
+		"Priority":        "1",
+		"Skipped":         "value",
+		"Privilege":       "call,all",
+	}
+
+	var h testHangup
+	c.Assert(Unmarshal(m, &h), check.IsNil)
+	c.Assert(h.Event, check.Equals, "Hangup")
+	c.Assert(h.Channel, check.Equals, "SIP/100-00000001")
+	c.Assert(h.Uniqueid, check.Equals, "1521635281.12")
+	c.Assert(h.State, check.Equals, 6)
+	c.Assert(*h.Cause, check.Equals, 16)
+	c.Assert(h.Missing, check.IsNil)
+	c.Assert(h.Answered, check.Equals, true)
+	c.Assert(h.Recording, check.Equals, false)
+	c.Assert(h.Duration, check.Equals, time.Minute+5*time.Second)
+	c.Assert(h.BillSec, check.Equals, 42*time.Second)
+	c.Assert(h.Timestamp.Equal(time.Unix(1521635281, 123456000)), check.Equals, true)
+	c.Assert(h.Start.Equal(time.Date(2018, 3, 21, 12, 28, 1, 0, time.Local)), check.Equals, true)
+	c.Assert(h.Vars, check.DeepEquals, []string{"A=1", "B=2"})
+	c.Assert(h.Priority, check.Equals, uint8(1))
+	c.Assert(h.Skipped, check.Equals, "")
+	c.Assert(h.Other, check.DeepEquals, map[string]string{"Skipped": "value", "Privilege": "call,all"})
+}
+
+func (s *UnitSuite) TestUnmarshalConversions(c *check.C) {
+	var v struct {
+		F bool
+		D time.Duration
+		T time.Time
+		X float64
+	}
+
+	for _, val := range []string{"yes", "Yes", "true", "1", "on"} {
+		c.Assert(Unmarshal(Message{"F": val}, &v), check.IsNil)
+		c.Assert(v.F, check.Equals, true, check.Commentf(val))
+	}
+	for _, val := range []string{"no", "false", "0", "off"} {
+		c.Assert(Unmarshal(Message{"f": val}, &v), check.IsNil)
+		c.Assert(v.F, check.Equals, false, check.Commentf(val))
+	}
+
+	for val, d := range map[string]time.Duration{
+		"1.5":      1500 * time.Millisecond,
+		"01:00:00": time.Hour,
+		"1m30s":    90 * time.Second,
+	} {
+		c.Assert(Unmarshal(Message{"D": val}, &v), check.IsNil)
+		c.Assert(v.D, check.Equals, d)
+	}
+
+	c.Assert(Unmarshal(Message{"T": "2018-03-21T12:28:01Z", "X": "0.25"}, &v), check.IsNil)
+	c.Assert(v.T.Equal(time.Date(2018, 3, 21, 12, 28, 1, 0, time.UTC)), check.Equals, true)
+	c.Assert(v.X, check.Equals, 0.25)
+
+	// empty value keeps field
+	c.Assert(Unmarshal(Message{"X": ""}, &v), check.IsNil)
+	c.Assert(v.X, check.Equals, 0.25)
+}
+
+func (s *UnitSuite) TestUnmarshalErrors(c *check.C) {
+	var v struct {
+		N int
+		F bool
+		P uint8
+	}
+
+	for _, m := range []Message{{"N": "abc"}, {"F": "maybe"}, {"P": "300"}} {
+		err := Unmarshal(m, &v)
+		c.Assert(errors.Is(err, ErrUnmarshal), check.Equals, true, check.Commentf("%v", m))
+	}
+
+	c.Assert(errors.Is(Unmarshal(Message{}, v), ErrUnmarshal), check.Equals, true)
+	c.Assert(errors.Is(Unmarshal(Message{}, nil), ErrUnmarshal), check.Equals, true)
+
+	var r struct {
+		Rest map[string]int `ami:",rest"`
+	}
+	c.Assert(errors.Is(Unmarshal(Message{}, &r), ErrUnmarshal), check.Equals, true)
+
+	var u struct {
+		rest map[string]string `ami:",rest"`
+	}
+	c.Assert(errors.Is(Unmarshal(Message{"A": "1"}, &u), ErrUnmarshal), check.Equals, true)
+
+	var p struct {
+		*testChannel
+	}
+	c.Assert(errors.Is(Unmarshal(Message{"Channel": "SIP/100"}, &p), ErrUnmarshal), check.Equals, true)
+}
+
+type BridgeHeaders struct {
+	BridgeUniqueid string
+}
+
+func (s *UnitSuite) TestUnmarshalEmbeddedPointer(c *check.C) {
+	var v struct {
+		*BridgeHeaders
+		Event string
+	}
+
+	c.Assert(Unmarshal(Message{"Event": "BridgeEnter", "BridgeUniqueid": "b1"}, &v), check.IsNil)
+	c.Assert(v.BridgeHeaders, check.NotNil)
+	c.Assert(v.BridgeUniqueid, check.Equals, "b1")
+
+	// existing value is reused, absent headers leave nil pointer
+	b := v.BridgeHeaders
+	c.Assert(Unmarshal(Message{"BridgeUniqueid": "b2"}, &v), check.IsNil)
+	c.Assert(v.BridgeHeaders, check.Equals, b)
+	c.Assert(b.BridgeUniqueid, check.Equals, "b2")
+
+	v.BridgeHeaders = nil
+	c.Assert(Unmarshal(Message{"Event": "Hangup"}, &v), check.IsNil)
+	c.Assert(v.BridgeHeaders, check.IsNil)
+}
@@ -0,0 +1,144 @@
+package gami
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version, AMI protocol version (banner "Asterisk Call Manager/5.0.1")
+// 1.1 - Asterisk 1.6/1.8, 1.3 - 11, 2.x - 12/13, 3.x - 14, 4.x - 15, 5.x - 16
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+var (
+	AMI12 = Version{Major: 2} // Asterisk 12, bridging framework
+	AMI14 = Version{Major: 3} // Asterisk 14, Command output in Output headers
+)
+
+// ParseVersion, parses "x.y[.z]" version or whole banner line
+func ParseVersion(s string) (Version, error) {
+
+	if i := strings.LastIndex(s, "/"); i != -1 {
+		s = s[i+1:]
+	}
+
+	var v Version
+	parts := strings.Split(strings.TrimSpace(s), ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v, fmt.Errorf("gami: malformed version %q", s)
+	}
+
+	dst := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("gami: malformed version %q", s)
+		}
+		*dst[i] = n
+	}
+
+	return v, nil
+}
+
+// String, Stringer interface implementation
+func (v Version) String() string {
+
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Less, true if v is lower than o
+func (v Version) Less(o Version) bool {
+
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+
+	return v.Patch < o.Patch
+}
+
+// IsZero, true if version is unknown (banner not received)
+func (v Version) IsZero() bool {
+
+	return v == Version{}
+}
+
+// Version, returns AMI version of connected Asterisk (zero if banner not received)
+func (a *Asterisk) Version() Version {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.version
+}
+
+// setBanner, parses Asterisk banner
+func (a *Asterisk) setBanner(banner string) {
+
+	v, err := ParseVersion(banner)
+	if err != nil {
+		a.reportError(err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.version = v
+}
+
+// RequireVersion, Login fails with ErrUnsupportedVersion if Asterisk AMI version is lower
+// (or unknown) than v, version is checked by banner before credentials are sent
+func (a *Asterisk) RequireVersion(v Version) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.minVersion = v
+}
+
+// waitVersion, waits for banner (if version is required) and checks version
+func (a *Asterisk) waitVersion(ctx context.Context, ready chan struct{}) error {
+
+	a.mu.RLock()
+	required := !a.minVersion.IsZero()
+	a.mu.RUnlock()
+
+	if !required {
+		return nil
+	}
+
+	t := time.NewTimer(_BANNER_TMOUT)
+	defer t.Stop()
+
+	select {
+	case <-ready:
+	case <-t.C:
+	case <-ctx.Done():
+		return contextError(ctx)
+	}
+
+	return a.checkVersion()
+}
+
+// checkVersion, checks required version
+func (a *Asterisk) checkVersion() error {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.minVersion.IsZero() {
+		return nil
+	}
+
+	if a.version.IsZero() || a.version.Less(a.minVersion) {
+		return fmt.Errorf("%w: %s, required %s", ErrUnsupportedVersion, a.version, a.minVersion)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package gami
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *UnitSuite) TestParseVersion(c *check.C) {
+	v, err := ParseVersion("Asterisk Call Manager/5.0.1")
+	c.Assert(err, check.IsNil)
+	c.Assert(v, check.Equals, Version{5, 0, 1})
+	c.Assert(v.String(), check.Equals, "5.0.1")
+
+	v, err = ParseVersion("1.1")
+	c.Assert(err, check.IsNil)
+	c.Assert(v, check.Equals, Version{1, 1, 0})
+	c.Assert(v.Less(AMI12), check.Equals, true)
+	c.Assert(AMI14.Less(Version{5, 0, 1}), check.Equals, true)
+	c.Assert(Version{2, 5, 0}.Less(Version{2, 4, 9}), check.Equals, false)
+
+	for _, bad := range []string{"", "5", "Asterisk Call Manager/x.y", "1.2.3.4", "1.-1"} {
+		_, err = ParseVersion(bad)
+		c.Assert(err, check.NotNil, check.Commentf(bad))
+	}
+}
+
+// bannerAsterisk, Asterisk connected to mock sending banner
+func bannerAsterisk(c *check.C, banner string, min Version) (*Asterisk, net.Conn, error) {
+	cln, srv := net.Pipe()
+	go func() {
+		srv.Write([]byte(banner + "\r\n"))
+		servePipe(srv, func(Message) []Message { return nil })
+	}()
+
+	a := NewAsterisk(&cln, nil)
+	a.RequireVersion(min)
+	err := a.Login("admin", "admin")
+
+	return a, srv, err
+}
+
+func (s *UnitSuite) TestVersion(c *check.C) {
+	a, srv, err := bannerAsterisk(c, "Asterisk Call Manager/5.0.1", Version{2, 0, 0})
+	defer srv.Close()
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Version(), check.Equals, Version{5, 0, 1})
+	c.Assert(bridgeAction(a.Version(), "SIP/1", "SIP/2", true)["Tone"], check.Equals, "Channel2")
+
+	_, srv2, err := bannerAsterisk(c, "Asterisk Call Manager/1.1", Version{2, 0, 0})
+	defer srv2.Close()
+	c.Assert(errors.Is(err, ErrUnsupportedVersion), check.Equals, true)
+	c.Assert(bridgeAction(Version{1, 1, 0}, "SIP/1", "SIP/2", true)["Tone"], check.Equals, "yes")
+}
+
+func (s *UnitSuite) TestVersionBeforeLogin(c *check.C) {
+	cln, srv := net.Pipe()
+	defer srv.Close()
+
+	actions := make(chan string, 10)
+	go func() {
+		srv.Write([]byte("Asterisk Call Manager/1.1\r\n"))
+		servePipe(srv, func(m Message) []Message {
+			actions <- m["Action"]
+			return nil
+		})
+	}()
+
+	a := NewAsterisk(&cln, nil)
+	a.RequireVersion(AMI12)
+	c.Assert(errors.Is(a.Login("admin", "admin"), ErrUnsupportedVersion), check.Equals, true)
+	c.Assert(a.isAuthorized(), check.Equals, false)
+
+	// credentials never sent
+	select {
+	case act := <-actions:
+		c.Fatalf("unexpected action %s", act)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
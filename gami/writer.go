@@ -0,0 +1,154 @@
+package gami
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	_WRITE_BUF   = 65536            // max retained coalescing buffer size
+	_WRITE_TMOUT = 10 * time.Second // default write deadline
+)
+
+// writeReq, packet waiting for writer
+type writeReq struct {
+	p   []byte
+	err chan error // result of write, buffered
+}
+
+// writer, serializes packets from all goroutines, packets queued during write are coalesced
+// into one write, single writer goroutine runs while queue is not empty
+type writer struct {
+	mu      *sync.Mutex
+	q       []*writeReq
+	running bool
+	timeout time.Duration // deadline for each write, 0 - no deadline
+	conn    func() net.Conn
+	buf     []byte // coalescing buffer, used by writer goroutine only
+}
+
+// newWriter, writer factory
+func newWriter(conn func() net.Conn) *writer {
+
+	return &writer{
+		mu:      &sync.Mutex{},
+		timeout: _WRITE_TMOUT,
+		conn:    conn,
+	}
+}
+
+// write, enqueues packet and waits until it is written or ctx is done
+// (packet still queued is dropped then, packet being written is completed)
+func (w *writer) write(ctx context.Context, p []byte) error {
+
+	r := &writeReq{p: p, err: make(chan error, 1)}
+
+	w.mu.Lock()
+	w.q = append(w.q, r)
+	if !w.running {
+		w.running = true
+		go w.flush()
+	}
+	w.mu.Unlock()
+
+	select {
+	case err := <-r.err:
+		return err
+	case <-ctx.Done():
+		w.drop(r)
+		return contextError(ctx)
+	}
+}
+
+// drop, removes packet from queue if writer has not taken it yet
+func (w *writer) drop(r *writeReq) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, qr := range w.q {
+		if qr == r {
+			w.q = append(w.q[:i], w.q[i+1:]...)
+			return
+		}
+	}
+}
+
+// setTimeout, changes write deadline
+func (w *writer) setTimeout(d time.Duration) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timeout = d
+}
+
+// flush, writer goroutine, writes queued packets until queue is empty
+func (w *writer) flush() {
+
+	for {
+		w.mu.Lock()
+		q, tm := w.q, w.timeout
+		w.q = nil
+		if len(q) == 0 {
+			w.running = false
+			w.mu.Unlock()
+			return
+		}
+		w.mu.Unlock()
+
+		buf := w.buf[:0]
+		for _, r := range q {
+			buf = append(buf, r.p...)
+		}
+		if cap(buf) <= _WRITE_BUF {
+			w.buf = buf
+		}
+
+		n, err := w.send(buf, tm)
+
+		// packets written completely before failure are succeeded
+		for _, r := range q {
+			if n >= len(r.p) {
+				n -= len(r.p)
+				r.err <- nil
+			} else {
+				n = 0
+				r.err <- err
+			}
+		}
+	}
+}
+
+// send, writes buffer to current connection with deadline
+// on failure connection is closed (stream is broken), reader reports connection loss
+func (w *writer) send(p []byte, tm time.Duration) (int, error) {
+
+	conn := w.conn()
+
+	if tm > 0 {
+		if err := conn.SetWriteDeadline(time.Now().Add(tm)); err != nil {
+			conn.Close()
+			return 0, err
+		}
+	}
+
+	n, err := conn.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+
+	if err != nil {
+		conn.Close()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			err = fmt.Errorf("%w: %w", ErrTimeout, err)
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,120 @@
+package gami
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	check "gopkg.in/check.v1"
+)
+
+func (s *UnitSuite) TestWriterConcurrent(c *check.C) {
+	cln, srv := net.Pipe()
+	defer srv.Close()
+
+	a := NewAsterisk(&cln, nil)
+	pad := strings.Repeat("x", 2048) // bigger than pipe chunks, interleaving would break packets
+
+	const n = 200
+	got := make(chan map[string]bool)
+	go func() {
+		seen := map[string]bool{}
+		p := newParser(srv, 0)
+		for i := 0; i < n; i++ {
+			h, err := p.next()
+			if err != nil || h.Get("Data") != pad {
+				break
+			}
+			seen[h.Get("UserEvent")] = true
+		}
+		got <- seen
+	}()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.Check(a.send(context.Background(), Message{"Action": "UserEvent", "UserEvent": fmt.Sprint(i), "Data": pad}), check.IsNil)
+		}(i)
+	}
+	wg.Wait()
+
+	seen := <-got
+	c.Assert(seen, check.HasLen, n)
+}
+
+func (s *UnitSuite) TestWriterTimeout(c *check.C) {
+	cln, srv := net.Pipe() // nobody reads, socket is stuck
+	defer srv.Close()
+
+	a := NewAsterisk(&cln, nil)
+	a.SetWriteTimeout(50 * time.Millisecond)
+
+	start := time.Now()
+	err := a.send(context.Background(), Message{"Action": "Ping"})
+	c.Assert(errors.Is(err, ErrTimeout), check.Equals, true)
+	c.Assert(time.Since(start) < time.Second, check.Equals, true)
+
+	// broken connection is closed
+	_, err = cln.Read(make([]byte, 1))
+	c.Assert(err, check.NotNil)
+}
+
+func (s *UnitSuite) TestWriterContext(c *check.C) {
+	cln, srv := net.Pipe() // nobody reads, socket is stuck
+	defer srv.Close()
+
+	a := NewAsterisk(&cln, nil)
+	a.SetWriteTimeout(0) // no write deadline, only ctx limits wait
+	a.setAuthorized(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	m := Message{"Action": "Ping"}
+	_, err := a.SendActionContext(ctx, m)
+	c.Assert(errors.Is(err, ErrTimeout), check.Equals, true)
+	c.Assert(time.Since(start) < time.Second, check.Equals, true)
+	f, _ := a.actionHandlers.get(m["ActionID"])
+	c.Assert(f, check.IsNil)
+
+	// packet queued behind stuck one is dropped
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c.Assert(errors.Is(a.send(ctx, Message{"Action": "Ping"}), ErrTimeout), check.Equals, true)
+	a.wr.mu.Lock()
+	c.Assert(a.wr.q, check.HasLen, 0)
+	a.wr.mu.Unlock()
+}
+
+func (s *UnitSuite) TestWriterPartial(c *check.C) {
+	cln, srv := net.Pipe()
+	defer srv.Close()
+
+	w := newWriter(func() net.Conn { return cln })
+	w.setTimeout(100 * time.Millisecond)
+
+	// first packet is read, second is not
+	first := []byte("Action: Ping\r\n\r\n")
+	go io.ReadFull(srv, make([]byte, len(first)))
+
+	// both packets in one coalesced write
+	rl := []*writeReq{
+		{p: first, err: make(chan error, 1)},
+		{p: []byte("Action: Logoff\r\n\r\n"), err: make(chan error, 1)},
+	}
+	w.q = rl
+	w.running = true
+	w.flush()
+
+	c.Assert(<-rl[0].err, check.IsNil)
+	c.Assert(errors.Is(<-rl[1].err, ErrTimeout), check.Equals, true)
+	c.Assert(w.running, check.Equals, false)
+}